@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/log"
+	"github.com/spf13/cobra"
+)
+
+var eventsStore string
+var eventsOutput string
+var eventsQuery log.StoreQuery
+var eventsSince, eventsUntil string
+var eventsTop int
+
+// eventsCmd reads back the events kept by karmor logs --store
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Query the alerts and logs kept by karmor logs --store",
+	Long: `Query the alerts and logs kept in the local event store by karmor logs --store,
+without a cluster. The store is locked while karmor logs --store is writing to it.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// the store is local, no cluster needed
+		return nil
+	},
+}
+
+// eventsQueryCmd lists the stored events matching the filters
+var eventsQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "List the stored events matching the filters",
+	Long: `List the stored events matching the filters, oldest first.
+
+--namespace, --pod, --policy and --operation are looked up in the indexes of the store,
+--where is any filter expression of karmor logs --filter.`,
+	Example: `  # Blocked accesses in prod in the last hour:
+  karmor events query --since 1h --namespace prod --where 'Action==Block'
+
+  # The last 20 alerts of a policy as JSON lines:
+  karmor events query --policy block-shadow --limit 20 -o json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if eventsOutput != "table" && eventsOutput != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", eventsOutput)
+		}
+		now := time.Now()
+		var err error
+		if eventsQuery.Since, err = log.ParseTime(eventsSince, now); err != nil {
+			return err
+		}
+		if eventsQuery.Until, err = log.ParseTime(eventsUntil, now); err != nil {
+			return err
+		}
+		events, err := log.QueryEvents(eventsStore, eventsQuery)
+		if err != nil {
+			return err
+		}
+		return log.WriteEvents(os.Stdout, events, eventsOutput)
+	},
+}
+
+// eventsStatsCmd summarises the store
+var eventsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarise the stored events",
+	Long:  `Show the number of stored events, their time range, the retention and the most frequent namespaces, pods, policies and operations.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if eventsOutput != "table" && eventsOutput != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", eventsOutput)
+		}
+		st, err := log.GetStoreStats(eventsStore, eventsTop)
+		if err != nil {
+			return err
+		}
+		return log.WriteStoreStats(os.Stdout, st, eventsOutput)
+	},
+}
+
+// eventsReportCmd summarises the tags of the stored alerts
+var eventsReportCmd = &cobra.Command{
+	Use:   "report <mitre|compliance>",
+	Short: "Summarise the stored alerts by their MITRE or compliance tags",
+	Long: `Summarise the stored alerts matching the filters by the tags of their policies: the hits and
+the affected workloads of every MITRE ATT&CK technique per tactic with mitre, or of every control
+per framework (MITRE, NIST, PCI-DSS, CIS, STIG...) with compliance.`,
+	Example: `  # MITRE ATT&CK matrix of the last day as Markdown:
+  karmor events report mitre --since 24h -o markdown
+
+  # Compliance report of prod as HTML:
+  karmor events report compliance --namespace prod -o html > report.html`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report, err := log.NewTagReport(args[0])
+		if err != nil {
+			return err
+		}
+		// the tables of the other subcommands are the text report
+		format := eventsOutput
+		if format == "table" {
+			format = "text"
+		}
+		if err := log.ValidReportFormat(format); err != nil {
+			return err
+		}
+		now := time.Now()
+		if eventsQuery.Since, err = log.ParseTime(eventsSince, now); err != nil {
+			return err
+		}
+		if eventsQuery.Until, err = log.ParseTime(eventsUntil, now); err != nil {
+			return err
+		}
+		eventsQuery.Type = "Alert"
+		events, err := log.QueryEvents(eventsStore, eventsQuery)
+		if err != nil {
+			return err
+		}
+		report.AddEvents(events)
+		return report.Write(os.Stdout, format)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.AddCommand(eventsQueryCmd)
+	eventsCmd.AddCommand(eventsStatsCmd)
+	eventsCmd.AddCommand(eventsReportCmd)
+
+	eventsCmd.PersistentFlags().StringVar(&eventsStore, "store", log.DefaultStorePath(), "Event store file written by karmor logs --store")
+	eventsCmd.PersistentFlags().StringVarP(&eventsOutput, "output", "o", "table", "Output format: table or json, or table, markdown or html for report")
+
+	eventsQueryCmd.Flags().StringVar(&eventsSince, "since", "", "Only show events since a RFC3339 time or a duration ago, e.g. 1h")
+	eventsQueryCmd.Flags().StringVar(&eventsUntil, "until", "", "Only show events until a RFC3339 time or a duration ago")
+	eventsQueryCmd.Flags().StringVar(&eventsQuery.Where, "where", "", "Boolean filter expression, e.g. 'Operation==File AND Action==Block'")
+	eventsQueryCmd.Flags().StringVar(&eventsQuery.Type, "type", "", "Only show events of this type, {Alert|Log}")
+	eventsQueryCmd.Flags().StringVarP(&eventsQuery.Namespace, "namespace", "n", "", "k8s namespace filter")
+	eventsQueryCmd.Flags().StringVar(&eventsQuery.Pod, "pod", "", "name of the pod")
+	eventsQueryCmd.Flags().StringVar(&eventsQuery.Policy, "policy", "", "name of the policy")
+	eventsQueryCmd.Flags().StringVar(&eventsQuery.Operation, "operation", "", "type of the operation (Eg:Process/File/Network)")
+	eventsQueryCmd.Flags().IntVar(&eventsQuery.Limit, "limit", 0, "Only show the most recent events, 0 for all")
+
+	eventsStatsCmd.Flags().IntVar(&eventsTop, "top", 5, "Number of the most frequent values shown per index")
+
+	eventsReportCmd.Flags().StringVar(&eventsSince, "since", "", "Only report alerts since a RFC3339 time or a duration ago, e.g. 24h")
+	eventsReportCmd.Flags().StringVar(&eventsUntil, "until", "", "Only report alerts until a RFC3339 time or a duration ago")
+	eventsReportCmd.Flags().StringVar(&eventsQuery.Where, "where", "", "Boolean filter expression, e.g. 'Action==Block'")
+	eventsReportCmd.Flags().StringVarP(&eventsQuery.Namespace, "namespace", "n", "", "k8s namespace filter")
+	eventsReportCmd.Flags().StringVar(&eventsQuery.Policy, "policy", "", "name of the policy")
+}
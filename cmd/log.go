@@ -4,11 +4,23 @@
 package cmd
 
 import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
 	"github.com/kubearmor/kubearmor-client/log"
+	"github.com/kubearmor/kubearmor-client/log/tui"
 	"github.com/spf13/cobra"
 )
 
 var logOptions log.Options
+var replaySpeed string
+var logSince, logUntil string
+var logTUI bool
+
+var treeOptions log.Options
+var treeInterval time.Duration
 
 // logCmd represents the log command
 var logCmd = &cobra.Command{
@@ -26,12 +38,77 @@ Connection Options:
   • --tlsCertPath <path>      local directory containing ca.crt, client.crt & client.key
   • --tlsCertProvider <mode>  certificate provisioning: “self” (auto‑generate) or “external”
   • --readCAFromSecret        fetch CA cert from in‑cluster secret (default true)
+  • --tls-ca <file>           CA bundle to verify the server with, implies TLS without a cluster
+  • --tls-cert, --tls-key     client certificate and key for mutual TLS
+  • --tls-server-name <name>  server name to verify instead of the --gRPC host
+  • --tls-insecure-skip-verify  do not verify the server certificate
+  • --contexts <ctx1,ctx2>    observe the relays of several kubeconfig contexts at once
+  • --all-contexts            observe every context in the kubeconfig
+  • --max-retries <n>         reconnect attempts after the stream drops (0 to exit instead)
+  • --retry-backoff <dur>     initial delay between reconnect attempts, doubled up to 1m
+  • --from pods               follow the stdout of the kubearmor-app=kubearmor pods through the
+                              Kubernetes API instead of the relay, e.g. during a relay outage;
+                              needs EnableStdOutAlerts/EnableStdOutLogs in the KubeArmorConfig
+  • --direct                  port-forward to every KubeArmor daemon instead of the relay and
+                              merge their streams, every event carries its NodeName
+  • --nodes <n1,n2>           nodes watched with --direct, by name, glob (worker-*) or label
+                              selector (node-role.kubernetes.io/worker=)
+  • --file <path>             read the JSON alerts and logs KubeArmor writes to a file instead of
+                              connecting, no kubeconfig needed, may be repeated
+  • --follow                  keep following --file through rotation and truncation, as tail -F,
+                              from its end unless --since is given
 
 Output Control:
   • --msgPath <path|stdout|none>   where to write raw event messages
   • --logPath <path|stdout|none>   where to write human‑readable alerts & logs
-  • --output, -o <text|json|pretty-json>  choose your output format
+  • --sink <spec>                  additional output sinks, may be repeated:
+                                   stdout, file:<path>[,maxSize=<MB>,maxAge=7d,maxBackups=<n>,compress],
+                                   syslog:<udp|tcp|unix>://<addr>, webhook:<url>[,batch=<n>,retries=<n>],
+                                   otlp:<grpc|http>://<collector>[,batch=<n>,retries=<n>] for
+                                   OpenTelemetry log records with k8s resource attributes
+  • --output, -o <text|json|pretty-json|cef|leef|ecs>  choose your output format
   • --json                       shorthand to force JSON output
+  • --aggregate <30s>            print one rolled-up line per group and window instead of every
+                                 event, and a table of the top groups at exit
+  • --group-by <f1,f2>           fields to aggregate by (default PolicyName,PodName,Resource)
+  • --top <n>                    groups in the final summary (default 10)
+  • --report <mitre|compliance>  summarise the alerts by the MITRE tactics and techniques, or the
+                                 NIST/PCI-DSS/CIS... controls, their policies tag them with, at exit
+  • --report-format <text|markdown|html>, --report-out <file>  format and file of the report
+  • --process-tree               add the exec chain of the process to every alert, e.g.
+                                 containerd-shim → sh → curl, fed by the system logs
+  • --enrich                     add the action, message and tags of the matched policy and the
+                                 owning Deployment/StatefulSet/DaemonSet of the pod to alerts
+  • --redact                     mask AWS keys, JWTs, bearer tokens, password= arguments and
+                                 credentials in URLs found in command lines and paths
+  • --redact-rules <file>        YAML file of additional rules, e.g.
+                                 rules: [{name: internal-token, pattern: 'itk_[0-9a-f]{32}'}]
+                                 a group named "secret" limits the replacement to it
+  • --redact-mode <mask|hash>    replace secrets by [REDACTED], or by a short SHA-256 to tell
+                                 them apart without revealing them
+  • --exec-on-alert <'cmd args'> run a command for every alert, with the alert JSON on stdin and
+                                 KARMOR_POLICY, KARMOR_POD, KARMOR_NAMESPACE, KARMOR_ACTION,
+                                 KARMOR_CONTAINER_ID and KARMOR_HOST in its environment
+  • --exec-workers <n>           commands run at once (default 4), others are skipped
+  • --exec-timeout <30s>         time limit of every command
+  • --exec-rate <n>              commands started per second at most (default 1, 0 for unlimited)
+  • --record <file>              also write the raw alerts, logs & messages to a capture file
+  • --replay <file>              read events from a capture file instead of a cluster
+  • --speed <factor|max>         replay speed relative to the recording, e.g. 10x (default 1x)
+  • --store [file]               also keep the filtered events in a local store to query later
+                                 with karmor events (default ~/.karmor/events.db)
+  • --store-retention <168h>     prune stored events older than this (0 to keep them)
+  • --store-max-events <n>       prune the oldest stored events beyond this many (0 for no limit)
+  • --metrics-listen <addr>      serve Prometheus metrics on <addr>/metrics instead of printing,
+                                 reconnecting forever unless --max-retries is given
+  • --overflow <policy>          what to do when a slow consumer, e.g. the --tui or the metrics
+                                 exporter, falls --event-buffer events behind: block the streams,
+                                 drop-oldest, drop-newest or sample (default block, drop-oldest
+                                 with --tui)
+  • --event-buffer <n>           events buffered for slow consumers (default 1024)
+  • --tui                        browse the live alerts in an interactive table: (f) edit the
+                                 filter expression, (p) pause/resume, (enter) full JSON of an
+                                 alert, (e) export the filtered alerts to a JSON file, (q) quit
 
 Filtering:
   • --logFilter <policy|system|all>  type of logs to receive (default “policy” i.e alerts)
@@ -42,8 +119,22 @@ Filtering:
   • --pod <name>                      filter by pod name
   • --resource <cmd>                  filter by executed command
   • --source <binary>                 filter by system binary path
-  • --labels, -l <key=val,…>          label selectors to narrow pods/services
+  • --labels, -l <selector>           Kubernetes label selector, all terms must match, e.g.
+                                      'env in (prod,staging),!canary,tier!=frontend'
+  • --filter <expr>                   boolean filter expression over any alert/log field
+                                      (==, !=, =~, !~, <, <=, >, >=, in (…), AND, OR, NOT)
   • --limit <n>                       maximum number of events to print (0 for unlimited)
+  • --since <10m|RFC3339>             drop events older than this
+  • --until <RFC3339|10m>             drop events newer than this and stop observing then, a
+                                      duration is from now (ago with --replay)
+  • --duration <5m>                   collect for this long and exit
+
+CI Gates:
+  • --expect <expr>                   exit 0 as soon as an event matches, 1 if none did
+  • --timeout <2m>                    how long to wait for the --expect event
+  • --fail-on <expr>                  exit 1 as soon as an event matches, 0 if none did
+                                      by the end of --duration
+  • --junit <file>                    write the outcome as a JUnit XML report
 
 Examples:
   # Stream all policy‑related events in JSON by connecting to local kubearmor instance:
@@ -52,29 +143,212 @@ Examples:
   # Watch only file operations in namespace “prod”:
   karmor logs -n prod --operation File --logFilter all
 
+  # Blocked file access or high severity alerts, excluding debug pods:
+  karmor logs --filter 'Operation==File AND (Action==Block OR Severity>=5) AND NOT PodName=~^debug'
+
   # Persist alerts to a file in pretty JSON:
   karmor logs --msgPath stdout --logPath /var/log/kubearmor.json --output pretty-json
 
+  # Watch a systemd KubeArmor on a VM over mutual TLS, no cluster needed:
+  karmor logs --gRPC 10.0.0.5:32767 --tls-ca ca.crt --tls-cert client.crt --tls-key client.key
+
+  # Keep watching alerts while the relay is down, from the stdout of the KubeArmor pods:
+  karmor logs --from=pods
+
+  # Follow the alert file of a systemd KubeArmor on a VM:
+  karmor logs --file /var/log/kubearmor/alerts.json --follow
+
+  # Bypass the relay and watch the KubeArmor daemon of a single node:
+  karmor logs --direct --nodes worker-1
+
+  # Merge the alerts of two clusters, every event carries its ClusterContext:
+  karmor logs --contexts prod-eu,prod-us --json
+
+  # Ship alerts in Common Event Format to a SIEM over syslog:
+  karmor logs -o cef --sink syslog:tcp://siem.example.com:601
+
+  # Record an incident and replay it later at ten times the speed, without a cluster:
+  karmor logs --logFilter all --msgPath stdout --record incident.kalog
+  karmor logs --logFilter all --replay incident.kalog --speed 10x --filter 'Action==Block'
+
+  # Show how the process that raised an alert was started, or the live tree of a pod:
+  karmor logs --process-tree
+  karmor logs tree --pod web-7f9
+
+  # See what fired and for which workload without kubectl:
+  karmor logs --enrich --json
+
+  # Keep tokens and passwords of command lines out of the log files:
+  karmor logs --logFilter all --redact --logPath /var/log/kubearmor.json --json
+
+  # Snapshot the container of every blocked access with a local forensics script:
+  karmor logs --filter 'Action==Block' --exec-on-alert './snapshot.sh --reason blocked'
+
+  # Summarise noisy alerts every 30 seconds:
+  karmor logs --aggregate 30s --group-by PolicyName,PodName,Resource
+
+  # Collect five minutes of alerts after a deploy and exit:
+  karmor logs --duration 5m --json --logPath alerts.json
+
+  # Triage alerts interactively:
+  karmor logs --tui -n prod
+
+  # Fail an e2e test unless the policy blocks the shadow file within two minutes:
+  karmor logs --expect 'PolicyName==block-shadow AND Action==Block' --timeout 2m --junit result.xml
+
+  # Fail if anything is blocked during a five minute soak test:
+  karmor logs --fail-on 'Action==Block' --duration 5m --junit result.xml
+
+  # Keep a week of alerts locally and look up what was blocked in prod in the last hour:
+  karmor logs --store --store-retention 168h
+  karmor events query --since 1h --namespace prod --where 'Action==Block'
+
+  # MITRE ATT&CK matrix of an hour of alerts, as HTML:
+  karmor logs --report mitre --duration 1h --report-format html --report-out mitre.html
+
+  # Export alert and log counters for Prometheus:
+  karmor logs --logFilter all --metrics-listen :9464
+
+  # Ship alerts and logs to a local OpenTelemetry Collector:
+  karmor logs --logFilter all --sink otlp:grpc://localhost:4317
+
+  # Tee alerts to a rotating file and a syslog collector:
+  karmor logs --sink file:/var/log/karmor/alerts.log,maxSize=100,maxBackups=5,compress --sink syslog:udp://collector:514
+
 	Use "karmor logs --help" to see detailed flag descriptions and defaults.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// replaying a capture or reading log files does not need a cluster, nor
+		// does a KubeArmor reached with --gRPC and certificates from files, e.g.
+		// on a VM
+		if logOptions.Replay != "" || len(logOptions.Files) != 0 || (logOptions.GRPC != "" && logOptions.TLS.Enabled()) {
+			return nil
+		}
+		return rootCmd.PersistentPreRunE(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		log.StopChan = make(chan struct{})
+		// explicit sinks, the metrics exporter and the report replace the default stdout output
+		// unless --logPath is given too
+		if (len(logOptions.Sinks) != 0 || logOptions.MetricsListen != "" || logOptions.Report != "") && !cmd.Flags().Changed("logPath") {
+			logOptions.LogPath = "none"
+		}
+		now := time.Now()
+		var err error
+		if logOptions.Since, err = log.ParseTime(logSince, now); err != nil {
+			return err
+		}
+		// a duration is ahead for a live run, and back from now for a replay
+		parseUntil := log.ParseDeadline
+		if logOptions.Replay != "" {
+			parseUntil = log.ParseTime
+		}
+		if logOptions.Until, err = parseUntil(logUntil, now); err != nil {
+			return err
+		}
+		// the metrics exporter stays attached to the relay
+		if logOptions.MetricsListen != "" && !cmd.Flags().Changed("max-retries") {
+			logOptions.MaxRetries = -1
+		}
+		if len(logOptions.Files) != 0 && (logOptions.Replay != "" || logOptions.AllContexts || len(logOptions.Contexts) != 0) {
+			return errors.New("--file cannot be combined with --replay, --contexts or --all-contexts")
+		}
+		if logOptions.Replay != "" {
+			if logOptions.Record != "" {
+				return errors.New("--record and --replay are mutually exclusive")
+			}
+			// a replay has no cluster to enrich from, and feeds neither
+			// the TUI nor the metrics
+			if logOptions.Enrich || logTUI || logOptions.MetricsListen != "" {
+				return errors.New("--enrich, --tui and --metrics-listen cannot be combined with --replay")
+			}
+			speed, err := log.ParseReplaySpeed(replaySpeed)
+			if err != nil {
+				return err
+			}
+			logOptions.ReplaySpeed = speed
+			return log.StartReplay(logOptions)
+		}
+		if logOptions.Timeout > 0 && logOptions.Expect == "" {
+			return errors.New("--timeout is the time to wait for --expect")
+		}
+		if logOptions.JUnit != "" && logOptions.Expect == "" && logOptions.FailOn == "" {
+			return errors.New("--junit reports the outcome of --expect and --fail-on")
+		}
+		if logOptions.Enrich && k8sClient == nil {
+			return errors.New("--enrich needs a cluster to read the policies and pods from")
+		}
+		if logTUI {
+			if logOptions.AllContexts || len(logOptions.Contexts) != 0 {
+				return errors.New("--tui observes a single cluster, drop --contexts and --all-contexts")
+			}
+			// the table is the only output
+			if len(logOptions.Sinks) != 0 || cmd.Flags().Changed("logPath") || cmd.Flags().Changed("msgPath") ||
+				logOptions.LogFilter != "policy" {
+				return errors.New("--tui shows the policy alerts only, drop --sink, --logPath, --msgPath and --logFilter")
+			}
+			// keep the streams flowing while the table redraws
+			if !cmd.Flags().Changed("overflow") {
+				logOptions.Overflow = log.OverflowDropOldest
+			}
+			return tui.Start(k8sClient, logOptions)
+		}
+		if logOptions.AllContexts || len(logOptions.Contexts) != 0 {
+			contexts := logOptions.Contexts
+			if logOptions.AllContexts {
+				if contexts, err = k8s.ListContexts(); err != nil {
+					return err
+				}
+			}
+			return log.StartMultiClusterObserver(contexts, logOptions)
+		}
 		return log.StartObserver(k8sClient, logOptions)
 	},
 }
 
+// logTreeCmd renders the live process tree of containers
+var logTreeCmd = &cobra.Command{
+	Use:   "tree",
+	Short: "Show the live process tree of containers",
+	Long: `Show the live process tree of the containers matching the filters, built from the
+process events KubeArmor reports. The tree is redrawn every --interval until interrupted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return log.StartProcessTreeView(k8sClient, treeOptions, treeInterval)
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(logCmd)
+	logCmd.AddCommand(logTreeCmd)
+
+	logTreeCmd.Flags().StringVar(&treeOptions.GRPC, "gRPC", "", "gRPC server information")
+	logTreeCmd.Flags().StringVarP(&treeOptions.Namespace, "namespace", "n", "", "k8s namespace filter")
+	logTreeCmd.Flags().StringVar(&treeOptions.PodName, "pod", "", "name of the pod ")
+	logTreeCmd.Flags().StringVar(&treeOptions.ContainerName, "container", "", "name of the container ")
+	logTreeCmd.Flags().StringSliceVarP(&treeOptions.Selector, "labels", "l", []string{}, "Label selector of the pods, e.g. 'env in (prod,staging),tier!=frontend'")
+	logTreeCmd.Flags().DurationVar(&treeInterval, "interval", 2*time.Second, "Interval between redraws of the tree")
+	logTreeCmd.Flags().IntVar(&treeOptions.MaxRetries, "max-retries", 10, "number of reconnect attempts when the connection to KubeArmor drops, 0 to exit instead")
+	logTreeCmd.Flags().DurationVar(&treeOptions.RetryBackoff, "retry-backoff", time.Second, "initial delay between reconnect attempts, doubled with jitter on every attempt")
 
 	logCmd.Flags().StringVar(&logOptions.GRPC, "gRPC", "", "gRPC server information")
 	logCmd.Flags().BoolVar(&logOptions.Secure, "secure", false, "connect to kubearmor on a secure connection")
 	logCmd.Flags().StringVar(&logOptions.TlsCertPath, "tlsCertPath", "/var/lib/kubearmor/tls", "path to the ca.crt, client.crt, and client.key if certs are provided locally")
 	logCmd.Flags().StringVar(&logOptions.TlsCertProvider, "tlsCertProvider", "self", "{self|external} self: dynamically crete client certificates, external: provide client certificate and key with --tlsCertPath")
 	logCmd.Flags().BoolVar(&logOptions.ReadCAFromSecret, "readCAFromSecret", true, "true if ca cert to be read from k8s secret on cluster running kubearmor")
+	addTLSFlags(logCmd.Flags(), &logOptions.TLS)
+	logCmd.Flags().StringSliceVar(&logOptions.Contexts, "contexts", []string{}, "kubeconfig contexts whose KubeArmor relays are observed at once")
+	logCmd.Flags().BoolVar(&logOptions.AllContexts, "all-contexts", false, "observe the KubeArmor relays of every kubeconfig context")
+	logCmd.Flags().StringVar(&logOptions.LogSource, "from", log.SourceRelay, "where the telemetry is read from, relay or pods for the stdout of the KubeArmor pods (named --from, as --source filters the binaries)")
+	logCmd.Flags().BoolVar(&logOptions.Direct, "direct", false, "connect to every KubeArmor daemon through a port forward instead of the relay")
+	logCmd.Flags().StringSliceVar(&logOptions.Nodes, "nodes", []string{}, "nodes watched with --direct, by name, glob pattern or label selector")
+	logCmd.Flags().StringArrayVar(&logOptions.Files, "file", []string{}, "KubeArmor log file of JSON alerts and logs to read instead of connecting, may be repeated")
+	logCmd.Flags().BoolVar(&logOptions.Follow, "follow", false, "keep following --file for new lines, through rotation and truncation")
+	logCmd.Flags().IntVar(&logOptions.MaxRetries, "max-retries", 10, "number of reconnect attempts when the connection to KubeArmor drops, 0 to exit instead")
+	logCmd.Flags().DurationVar(&logOptions.RetryBackoff, "retry-backoff", time.Second, "initial delay between reconnect attempts, doubled with jitter on every attempt")
 	logCmd.Flags().StringVar(&logOptions.MsgPath, "msgPath", "none", "Output location for messages, {path|stdout|none}")
 	logCmd.Flags().StringVar(&logOptions.LogPath, "logPath", "stdout", "Output location for alerts and logs, {path|stdout|none}")
+	logCmd.Flags().StringArrayVar(&logOptions.Sinks, "sink", []string{}, log.SinkUsage)
 	logCmd.Flags().StringVar(&logOptions.LogFilter, "logFilter", "policy", "Filter for what kinds of alerts and logs to receive, {policy|system|all}")
 	logCmd.Flags().BoolVar(&logOptions.JSON, "json", false, "Flag to print alerts and logs in the JSON format")
-	logCmd.Flags().StringVarP(&logOptions.Output, "output", "o", "text", "Output format: text, json, or pretty-json")
+	logCmd.Flags().StringVarP(&logOptions.Output, "output", "o", "text", "Output format: text, json, pretty-json, cef, leef or ecs")
 	logCmd.Flags().StringVarP(&logOptions.Namespace, "namespace", "n", "", "k8s namespace filter")
 	logCmd.Flags().StringVar(&logOptions.Operation, "operation", "", "Give the type of the operation (Eg:Process/File/Network)")
 	logCmd.Flags().StringVar(&logOptions.LogType, "logType", "", "Log type you want (Eg:ContainerLog/HostLog) ")
@@ -83,5 +357,39 @@ func init() {
 	logCmd.Flags().StringVar(&logOptions.Resource, "resource", "", "command used by the user")
 	logCmd.Flags().StringVar(&logOptions.Source, "source", "", "binary used by the system ")
 	logCmd.Flags().Uint32Var(&logOptions.Limit, "limit", 0, "number of logs you want to see")
-	logCmd.Flags().StringSliceVarP(&logOptions.Selector, "labels", "l", []string{}, "use the labels to select the endpoints")
+	logCmd.Flags().StringVar(&logSince, "since", "", "Only show events since a RFC3339 time or a duration ago, e.g. 10m")
+	logCmd.Flags().StringVar(&logUntil, "until", "", "Only show events until a RFC3339 time or a duration from now (ago with --replay), and stop observing then")
+	logCmd.Flags().DurationVar(&logOptions.Duration, "duration", 0, "Collect events for this long and exit, e.g. 5m")
+	logCmd.Flags().StringVar(&logOptions.Filter, "filter", "", "Boolean filter expression, e.g. 'Operation==File AND (Action==Block OR Severity>=5)'")
+	logCmd.Flags().StringVar(&logOptions.Expect, "expect", "", "Filter expression of an event to wait for, exiting 1 if none is seen")
+	logCmd.Flags().DurationVar(&logOptions.Timeout, "timeout", 0, "How long to wait for the --expect event, e.g. 2m")
+	logCmd.Flags().StringVar(&logOptions.FailOn, "fail-on", "", "Filter expression of events exiting 1 as soon as one is seen")
+	logCmd.Flags().StringVar(&logOptions.JUnit, "junit", "", "JUnit XML file to write the outcome of --expect and --fail-on to")
+	logCmd.Flags().DurationVar(&logOptions.Aggregate, "aggregate", 0, "Roll alerts and logs up per group over this window instead of printing each, e.g. 30s")
+	logCmd.Flags().StringSliceVar(&logOptions.GroupBy, "group-by", log.DefaultGroupBy, "Fields to aggregate alerts and logs by with --aggregate")
+	logCmd.Flags().IntVar(&logOptions.Top, "top", 10, "Number of groups in the summary printed at exit with --aggregate")
+	logCmd.Flags().StringVar(&logOptions.Report, "report", "", "Summarise the alerts by their tags at exit, {mitre|compliance}")
+	logCmd.Flags().StringVar(&logOptions.ReportFormat, "report-format", "text", "Format of the --report: text, markdown or html")
+	logCmd.Flags().StringVar(&logOptions.ReportPath, "report-out", "", "File to write the --report to, stdout if empty")
+	logCmd.Flags().BoolVar(&logOptions.ProcessTree, "process-tree", false, "Add the exec chain of the process to every alert, reconstructed from the system logs")
+	logCmd.Flags().BoolVar(&logOptions.Enrich, "enrich", false, "Add the matched policy definition and the workload owning the pod to alerts")
+	logCmd.Flags().BoolVar(&logOptions.Redact, "redact", false, "Replace the secrets found by the built-in detectors in the alerts and logs written out")
+	logCmd.Flags().StringVar(&logOptions.RedactRules, "redact-rules", "", "YAML file of additional redaction rules, each a name and a regular expression")
+	logCmd.Flags().StringVar(&logOptions.RedactMode, "redact-mode", "mask", "How secrets are replaced: "+strings.Join(log.RedactModes, " or "))
+	logCmd.Flags().StringVar(&logOptions.ExecOnAlert, "exec-on-alert", "", "Command to run for every alert, with the alert JSON on stdin and its key fields in KARMOR_* variables")
+	logCmd.Flags().IntVar(&logOptions.ExecWorkers, "exec-workers", 4, "Number of --exec-on-alert commands run at once, alerts are skipped while all are busy")
+	logCmd.Flags().DurationVar(&logOptions.ExecTimeout, "exec-timeout", 30*time.Second, "Time limit of every --exec-on-alert command")
+	logCmd.Flags().Float64Var(&logOptions.ExecRate, "exec-rate", 1, "Number of --exec-on-alert commands started per second at most, 0 for unlimited")
+	logCmd.Flags().StringVar(&logOptions.MetricsListen, "metrics-listen", "", "Address to serve Prometheus metrics of the alerts and logs on, e.g. :9464")
+	logCmd.Flags().StringVar(&logOptions.Record, "record", "", "Capture file to record the raw alerts, logs and messages to")
+	logCmd.Flags().StringVar(&logOptions.Replay, "replay", "", "Capture file to replay instead of connecting to KubeArmor")
+	logCmd.Flags().StringVar(&logOptions.Store, "store", "", "Event store file to keep the filtered alerts and logs in, queried with karmor events")
+	logCmd.Flags().Lookup("store").NoOptDefVal = log.DefaultStorePath()
+	logCmd.Flags().DurationVar(&logOptions.StoreRetention, "store-retention", 7*24*time.Hour, "Prune stored events older than this, 0 to keep them")
+	logCmd.Flags().Uint64Var(&logOptions.StoreMaxEvents, "store-max-events", 1000000, "Prune the oldest stored events beyond this many, 0 for no limit")
+	logCmd.Flags().StringVar(&logOptions.Overflow, "overflow", log.OverflowBlock, "What to do once a slow consumer falls --event-buffer events behind: "+strings.Join(log.OverflowPolicies, ", "))
+	logCmd.Flags().IntVar(&logOptions.EventBuffer, "event-buffer", log.DefaultDeliveryBuffer, "Number of events buffered for slow consumers, see --overflow")
+	logCmd.Flags().BoolVar(&logTUI, "tui", false, "Browse the live alerts in an interactive terminal UI")
+	logCmd.Flags().StringVar(&replaySpeed, "speed", "1x", "Replay speed relative to the recording, e.g. 10x, 0.5x or max")
+	logCmd.Flags().StringSliceVarP(&logOptions.Selector, "labels", "l", []string{}, "Label selector of the pods, e.g. 'env in (prod,staging),tier!=frontend'")
 }
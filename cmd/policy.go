@@ -29,11 +29,16 @@ Subcommands:
 
 Global Flags:
   • --gRPC <address>   Address of the KubeArmor gRPC server (host:port)
+  • --tls-ca, --tls-cert, --tls-key, --tls-server-name, --tls-insecure-skip-verify
+                       connect over TLS or mutual TLS with certificates from files
 
 Examples:
   # Apply a file‑access policy on a standalone host:
   karmor vm policy add ./file-access.yaml --gRPC 127.0.0.1:50051
 
+  # Apply it to a KubeArmor requiring mutual TLS:
+  karmor vm policy add ./file-access.yaml --gRPC 10.0.0.5:32767 --tls-ca ca.crt --tls-cert client.crt --tls-key client.key
+
   # Remove that policy when finished:
   karmor vm policy delete ./file-access.yaml --gRPC 127.0.0.1:50051
 
@@ -94,4 +99,5 @@ func init() {
 
 	// gRPC endpoint flag to communicate with KubeArmor. Available across subcommands.
 	vmPolicyCmd.PersistentFlags().StringVar(&policyOptions.GRPC, "gRPC", "", "gRPC server information")
+	addTLSFlags(vmPolicyCmd.PersistentFlags(), &policyOptions.TLS)
 }
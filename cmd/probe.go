@@ -34,4 +34,5 @@ func init() {
 information on KubeArmor support in the environment and deletes daemonset after probing`)
 	probeCmd.Flags().StringVarP(&probeInstallOptions.Output, "format", "f", "text", "Format: json or text or no-color")
 	probeCmd.Flags().StringVar(&probeInstallOptions.GRPC, "gRPC", "", "GRPC port ")
+	addTLSFlags(probeCmd.Flags(), &probeInstallOptions.TLS)
 }
@@ -23,6 +23,8 @@ Filtering Options:
   • --namespace, -n <namespace>  only show logs from this Kubernetes namespace
   • --pod <pod-name>             only show logs from this pod
   • --container, -c <name>       only show logs from this container
+  • --labels, -l <selector>      only show logs of pods matching the label selector,
+                                 e.g. 'env in (prod,staging),!canary'
 
 Usage Examples:
   # Start the TUI connecting to a local agent:
@@ -37,8 +39,7 @@ Controls:
   • Ctrl+C        quit the TUI  
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		profileclient.Start()
-		return nil
+		return profileclient.Start()
 	},
 }
 
@@ -48,5 +49,6 @@ func init() {
 	profilecmd.Flags().StringVarP(&profileclient.ProfileOpts.Namespace, "namespace", "n", "", "Filter using namespace")
 	profilecmd.Flags().StringVar(&profileclient.ProfileOpts.Pod, "pod", "", "Filter using Pod name")
 	profilecmd.Flags().StringVarP(&profileclient.ProfileOpts.Container, "container", "c", "", "name of the container ")
+	profilecmd.Flags().StringSliceVarP(&profileclient.ProfileOpts.Labels, "labels", "l", []string{}, "Label selector of the pods, e.g. 'env in (prod,staging),tier!=frontend'")
 	profilecmd.Flags().BoolVar(&profileclient.ProfileOpts.Save, "save", false, "Save Profile data in json format")
 }
@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package cmd
+
+import (
+	"github.com/kubearmor/kubearmor-client/utils"
+	"github.com/spf13/pflag"
+)
+
+// addTLSFlags adds the flags to connect to the gRPC server of KubeArmor over
+// TLS with certificates from files
+func addTLSFlags(flags *pflag.FlagSet, o *utils.TLSOptions) {
+	flags.StringVar(&o.CA, "tls-ca", "", "CA bundle to verify the KubeArmor gRPC server with, the system CAs if not given")
+	flags.StringVar(&o.Cert, "tls-cert", "", "client certificate for mutual TLS with the KubeArmor gRPC server")
+	flags.StringVar(&o.Key, "tls-key", "", "key of the client certificate given with --tls-cert")
+	flags.StringVar(&o.ServerName, "tls-server-name", "", "server name to verify the certificate of the KubeArmor gRPC server against")
+	flags.BoolVar(&o.InsecureSkipVerify, "tls-insecure-skip-verify", false, "do not verify the certificate of the KubeArmor gRPC server")
+}
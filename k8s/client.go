@@ -6,6 +6,8 @@ package k8s
 
 import (
 	"context"
+	"sort"
+
 	"github.com/kubearmor/kubearmor-client/recommend/common"
 	"github.com/rs/zerolog/log"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
@@ -43,10 +45,16 @@ var (
 
 // ConnectK8sClient Function
 func ConnectK8sClient() (*Client, error) {
+	return ConnectK8sClientForContext(ContextName)
+}
+
+// ConnectK8sClientForContext connects to the cluster of the given kubeconfig
+// context, the current context is used if it is empty
+func ConnectK8sClientForContext(contextName string) (*Client, error) {
 	_ = kspAPI.AddToScheme(scheme.Scheme)
 
 	restClientGetter := genericclioptions.ConfigFlags{
-		Context:    &ContextName,
+		Context:    &contextName,
 		KubeConfig: &KubeConfig,
 	}
 	rawKubeConfigLoader := restClientGetter.ToRawKubeConfigLoader()
@@ -88,6 +96,24 @@ func ConnectK8sClient() (*Client, error) {
 	}, nil
 }
 
+// ListContexts returns the names of all the contexts in the kubeconfig
+func ListContexts() ([]string, error) {
+	restClientGetter := genericclioptions.ConfigFlags{
+		KubeConfig: &KubeConfig,
+	}
+	rawConfig, err := restClientGetter.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	contexts := make([]string, 0, len(rawConfig.Contexts))
+	for name := range rawConfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
 func GetKubeArmorCaSecret(client kubernetes.Interface) (string, string) {
 	secret, err := client.CoreV1().Secrets("").List(context.Background(), v1.ListOptions{
 		LabelSelector: v1.FormatLabelSelector(&v1.LabelSelector{MatchLabels: KubeArmorCALabels}),
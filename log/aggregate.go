@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// DefaultGroupBy are the fields aggregated events are grouped by if
+// Options.GroupBy is empty
+var DefaultGroupBy = []string{"PolicyName", "PodName", "Resource"}
+
+// aggregator rolls the filtered alerts and logs up into one record per group
+// and window, and prints the top groups of the whole run when closed
+type aggregator struct {
+	o       Options
+	window  time.Duration
+	names   []string   // group-by fields as given
+	paths   [][]string // group-by fields resolved
+	top     int
+	text    bool
+	started time.Time
+
+	mu     sync.Mutex
+	groups map[string]*aggGroup // current window
+	totals map[string]*aggGroup // whole run
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type aggGroup struct {
+	typ    string
+	values []string
+	count  int
+	first  time.Time
+	last   time.Time
+	sample []byte
+}
+
+// aggregateRecord is the JSON form of a rolled-up group
+type aggregateRecord struct {
+	Type           string            `json:"Type"`
+	EventType      string            `json:"EventType"`
+	Window         string            `json:"Window,omitempty"`
+	Group          map[string]string `json:"Group"`
+	Count          int               `json:"Count"`
+	FirstTimestamp string            `json:"FirstTimestamp"`
+	LastTimestamp  string            `json:"LastTimestamp"`
+	Sample         json.RawMessage   `json:"Sample,omitempty"`
+}
+
+// newAggregator starts rolling up events every o.Aggregate
+func newAggregator(o Options) (*aggregator, error) {
+	names := o.GroupBy
+	if len(names) == 0 {
+		names = DefaultGroupBy
+	}
+	a := &aggregator{
+		o:       o,
+		window:  o.Aggregate,
+		names:   names,
+		top:     o.Top,
+		text:    o.outputFormat() == "text",
+		started: time.Now(),
+		groups:  map[string]*aggGroup{},
+		totals:  map[string]*aggGroup{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, name := range names {
+		path, err := resolveField(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid group-by field: %w", err)
+		}
+		a.paths = append(a.paths, path)
+	}
+	if a.top <= 0 {
+		a.top = 10
+	}
+
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.flush()
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+	return a, nil
+}
+
+// add counts an event that passed the filters
+func (a *aggregator) add(t string, res map[string]interface{}, arr []byte) {
+	values := make([]string, len(a.paths))
+	for i, path := range a.paths {
+		if vals, ok := lookupField(res, path); ok {
+			strs := make([]string, 0, len(vals))
+			for _, v := range vals {
+				strs = append(strs, valueString(v))
+			}
+			values[i] = strings.Join(strs, ",")
+		}
+	}
+	key := t + "\x00" + strings.Join(values, "\x00")
+	ts := eventTime(res)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, groups := range []map[string]*aggGroup{a.groups, a.totals} {
+		g, ok := groups[key]
+		if !ok {
+			g = &aggGroup{typ: t, values: values, first: ts, sample: arr}
+			groups[key] = g
+		}
+		g.count++
+		if ts.Before(g.first) {
+			g.first = ts
+		}
+		if ts.After(g.last) {
+			g.last = ts
+		}
+	}
+}
+
+// flush writes one record per group of the current window
+func (a *aggregator) flush() {
+	a.mu.Lock()
+	groups := sortGroups(a.groups)
+	a.groups = map[string]*aggGroup{}
+	a.mu.Unlock()
+
+	for _, g := range groups {
+		rec := a.record(g)
+		arr, _ := json.Marshal(rec)
+		str := string(arr) + "\n"
+		if a.text {
+			str = fmt.Sprintf("%s .. %s  %s  x%d  %s\n  sample: %s\n",
+				g.first.Format(time.DateTime), g.last.Format(time.DateTime), g.typ, g.count,
+				strings.Join(a.pairs(g), "  "), string(g.sample))
+		}
+		a.o.emit(g.typ, arr, str)
+	}
+}
+
+// close flushes the last window and writes the top groups of the run
+func (a *aggregator) close() {
+	close(a.stop)
+	<-a.done
+	a.flush()
+
+	a.mu.Lock()
+	groups := sortGroups(a.totals)
+	a.mu.Unlock()
+	if len(groups) > a.top {
+		groups = groups[:a.top]
+	}
+
+	var recs []aggregateRecord
+	for _, g := range groups {
+		rec := a.record(g)
+		rec.Type, rec.Window, rec.Sample = "Summary", "", nil
+		recs = append(recs, rec)
+	}
+	arr, _ := json.Marshal(recs)
+	str := string(arr) + "\n"
+	if a.text {
+		str = a.table(groups)
+	}
+	a.o.emit("Summary", arr, str)
+}
+
+func (a *aggregator) record(g *aggGroup) aggregateRecord {
+	group := make(map[string]string, len(a.names))
+	for i, name := range a.names {
+		group[name] = g.values[i]
+	}
+	return aggregateRecord{
+		Type:           "Aggregate",
+		EventType:      g.typ,
+		Window:         a.window.String(),
+		Group:          group,
+		Count:          g.count,
+		FirstTimestamp: g.first.UTC().Format(time.RFC3339Nano),
+		LastTimestamp:  g.last.UTC().Format(time.RFC3339Nano),
+		Sample:         g.sample,
+	}
+}
+
+// pairs returns the group values as Field=value
+func (a *aggregator) pairs(g *aggGroup) []string {
+	pairs := make([]string, 0, len(a.names))
+	for i, name := range a.names {
+		pairs = append(pairs, name+"="+g.values[i])
+	}
+	return pairs
+}
+
+// table renders the top groups of the run
+func (a *aggregator) table(groups []*aggGroup) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "== Top %d of %s ==\n", len(groups), time.Since(a.started).Round(time.Second))
+
+	table := tablewriter.NewWriter(&sb)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(append(append([]string{"Type"}, a.names...), "Count", "First", "Last"))
+	for _, g := range groups {
+		row := append([]string{g.typ}, g.values...)
+		row = append(row, strconv.Itoa(g.count), g.first.Format(time.DateTime), g.last.Format(time.DateTime))
+		table.Append(row)
+	}
+	table.Render()
+	return sb.String()
+}
+
+// sortGroups orders groups by count, the most frequent first
+func sortGroups(groups map[string]*aggGroup) []*aggGroup {
+	sorted := make([]*aggGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].first.Before(sorted[j].first)
+	})
+	return sorted
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// aggregateEvents feeds alerts through the output pipeline with aggregation
+// and returns what was written
+func aggregateEvents(t *testing.T, o Options, alerts []*pb.Alert) string {
+	path := filepath.Join(t.TempDir(), "out.log")
+	o.LogPath = "none"
+	o.LogFilter = "policy"
+	o.Sinks = []string{"file:" + path}
+	o.Aggregate = time.Hour
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range alerts {
+		arr, _ := json.Marshal(a)
+		handleTelemetry(arr, "Alert", o)
+	}
+	o.release()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+var aggregateAlerts = []*pb.Alert{
+	{Timestamp: 1700000000, PolicyName: "block-shadow", PodName: "web", Resource: "/etc/shadow"},
+	{Timestamp: 1700000010, PolicyName: "block-shadow", PodName: "web", Resource: "/etc/shadow"},
+	{Timestamp: 1700000005, PolicyName: "block-shadow", PodName: "web", Resource: "/etc/shadow"},
+	{Timestamp: 1700000020, PolicyName: "audit-curl", PodName: "api", Resource: "/usr/bin/curl"},
+}
+
+func TestAggregateJSON(t *testing.T) {
+	out := aggregateEvents(t, Options{JSON: true, GroupBy: []string{"PolicyName", "podname"}}, aggregateAlerts)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 2 groups and a summary, got %q", out)
+	}
+
+	var first aggregateRecord
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Type != "Aggregate" || first.Count != 3 || first.Group["PolicyName"] != "block-shadow" ||
+		first.Group["podname"] != "web" || first.Window != "1h0m0s" {
+		t.Errorf("unexpected record %+v", first)
+	}
+	if first.FirstTimestamp != "2023-11-14T22:13:20Z" || first.LastTimestamp != "2023-11-14T22:13:30Z" {
+		t.Errorf("unexpected window %s .. %s", first.FirstTimestamp, first.LastTimestamp)
+	}
+	if !strings.Contains(string(first.Sample), `"Resource":"/etc/shadow"`) {
+		t.Errorf("unexpected sample %s", first.Sample)
+	}
+
+	var summary []aggregateRecord
+	if err := json.Unmarshal([]byte(lines[2]), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary) != 2 || summary[0].Type != "Summary" || summary[0].Count != 3 || summary[1].Count != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestAggregateTopTable(t *testing.T) {
+	out := aggregateEvents(t, Options{Top: 1}, aggregateAlerts)
+	if !strings.Contains(out, "x3  PolicyName=block-shadow  PodName=web  Resource=/etc/shadow") {
+		t.Errorf("missing the rolled-up line in %q", out)
+	}
+	summary := out[strings.Index(out, "== Top 1"):]
+	if !strings.Contains(summary, "block-shadow") || strings.Contains(summary, "audit-curl") {
+		t.Errorf("expected only the top group in the summary %q", summary)
+	}
+
+	if err := (&Options{Aggregate: time.Second, GroupBy: []string{"Nope"}}).prepare(); err == nil {
+		t.Error("expected an error for an unknown group-by field")
+	}
+}
@@ -0,0 +1,491 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/selfupdate"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// A capture file starts with captureMagic followed by a length-delimited
+// header and length-delimited records. Header and records are protobuf
+// encoded with the field numbers below, records wrap the raw telemetry.
+const captureMagic = "KALOG\x01"
+
+// header fields
+const (
+	capHeaderTimestamp protowire.Number = 1
+	capHeaderCluster   protowire.Number = 2
+	capHeaderVersion   protowire.Number = 3
+)
+
+// record fields
+const (
+	capRecordKind    protowire.Number = 1
+	capRecordTime    protowire.Number = 2
+	capRecordPayload protowire.Number = 3
+	capRecordContext protowire.Number = 4
+)
+
+// record kinds
+const (
+	capKindAlert   = 1
+	capKindLog     = 2
+	capKindMessage = 3
+)
+
+// maxCaptureRecord bounds the size of a record read from a capture
+const maxCaptureRecord = 16 << 20
+
+// CaptureHeader describes a capture file
+type CaptureHeader struct {
+	Timestamp time.Time // start of the recording
+	Cluster   string    // cluster or context the capture was taken from
+	Version   string    // karmor version that took the capture
+}
+
+// CaptureRecord is a single recorded alert, log or message
+type CaptureRecord struct {
+	Time    time.Time // time the event was received
+	Context string    // kubeconfig context, set for multi-cluster captures
+	Alert   *pb.Alert
+	Log     *pb.Log
+	Message *pb.Message
+}
+
+// ==================== //
+// == Capture Writer == //
+// ==================== //
+
+// CaptureWriter records raw telemetry to a capture file. It is safe for
+// concurrent use.
+type CaptureWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// NewCaptureWriter creates the capture file and writes its header
+func NewCaptureWriter(path string, hdr CaptureHeader) (*CaptureWriter, error) {
+	// #nosec
+	file, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file (%s, %s)", path, err.Error())
+	}
+	cw := &CaptureWriter{file: file, w: bufio.NewWriter(file)}
+
+	var b []byte
+	b = protowire.AppendTag(b, capHeaderTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(hdr.Timestamp.UnixNano()))
+	b = protowire.AppendTag(b, capHeaderCluster, protowire.BytesType)
+	b = protowire.AppendString(b, hdr.Cluster)
+	b = protowire.AppendTag(b, capHeaderVersion, protowire.BytesType)
+	b = protowire.AppendString(b, hdr.Version)
+
+	if _, err := cw.w.WriteString(captureMagic); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err := cw.writeDelimited(b); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *CaptureWriter) writeDelimited(b []byte) error {
+	if _, err := cw.w.Write(protowire.AppendVarint(nil, uint64(len(b)))); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(b)
+	return err
+}
+
+// Write appends a record to the capture
+func (cw *CaptureWriter) Write(rec CaptureRecord) error {
+	var kind uint64
+	var msg proto.Message
+	switch {
+	case rec.Alert != nil:
+		kind, msg = capKindAlert, rec.Alert
+	case rec.Log != nil:
+		kind, msg = capKindLog, rec.Log
+	case rec.Message != nil:
+		kind, msg = capKindMessage, rec.Message
+	default:
+		return errors.New("empty capture record")
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, capRecordKind, protowire.VarintType)
+	b = protowire.AppendVarint(b, kind)
+	b = protowire.AppendTag(b, capRecordTime, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(rec.Time.UnixNano()))
+	b = protowire.AppendTag(b, capRecordPayload, protowire.BytesType)
+	b = protowire.AppendBytes(b, payload)
+	if rec.Context != "" {
+		b = protowire.AppendTag(b, capRecordContext, protowire.BytesType)
+		b = protowire.AppendString(b, rec.Context)
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.file == nil {
+		return errors.New("capture file is closed")
+	}
+	return cw.writeDelimited(b)
+}
+
+// Close flushes and closes the capture file
+func (cw *CaptureWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.file == nil {
+		return nil
+	}
+	err := cw.w.Flush()
+	if cerr := cw.file.Close(); err == nil {
+		err = cerr
+	}
+	cw.file = nil
+	return err
+}
+
+// record writes an event to the capture if recording is enabled
+func (o Options) record(rec CaptureRecord) {
+	if o.recorder == nil {
+		return
+	}
+	rec.Context = o.clusterContext
+	if err := o.recorder.Write(rec); err != nil {
+		fmt.Fprintf(o.stderr(), "Failed to record event (%s)\n", err.Error())
+	}
+}
+
+// newRecorder opens the --record capture for the given targets
+func newRecorder(path string, targets []clusterTarget) (*CaptureWriter, error) {
+	var clusters []string
+	for _, t := range targets {
+		switch {
+		case t.context != "":
+			clusters = append(clusters, t.context)
+		case t.client != nil && t.client.RawConfig.CurrentContext != "":
+			clusters = append(clusters, t.client.RawConfig.CurrentContext)
+		}
+	}
+	return NewCaptureWriter(path, CaptureHeader{
+		Timestamp: time.Now(),
+		Cluster:   strings.Join(clusters, ","),
+		Version:   selfupdate.GitSummary,
+	})
+}
+
+// ==================== //
+// == Capture Reader == //
+// ==================== //
+
+// CaptureReader reads a capture file
+type CaptureReader struct {
+	Header CaptureHeader
+	r      *bufio.Reader
+}
+
+// NewCaptureReader reads the header of a capture
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(cr.r, magic); err != nil || string(magic) != captureMagic {
+		return nil, errors.New("not a karmor capture file")
+	}
+	b, err := cr.readDelimited()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture header: %w", err)
+	}
+
+	err = walkFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case capHeaderTimestamp:
+			cr.Header.Timestamp = time.Unix(0, int64(v))
+		case capHeaderCluster:
+			cr.Header.Cluster = string(data)
+		case capHeaderVersion:
+			cr.Header.Version = string(data)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture header: %w", err)
+	}
+	return cr, nil
+}
+
+func (cr *CaptureReader) readDelimited() ([]byte, error) {
+	size, err := readUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxCaptureRecord {
+		return nil, fmt.Errorf("record of %d bytes exceeds the limit", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+// Next returns the next record, io.EOF at the end of the capture
+func (cr *CaptureReader) Next() (CaptureRecord, error) {
+	var rec CaptureRecord
+	b, err := cr.readDelimited()
+	if err != nil {
+		return rec, err
+	}
+
+	var kind uint64
+	var payload []byte
+	err = walkFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case capRecordKind:
+			kind = v
+		case capRecordTime:
+			rec.Time = time.Unix(0, int64(v))
+		case capRecordPayload:
+			payload = data
+		case capRecordContext:
+			rec.Context = string(data)
+		}
+	})
+	if err != nil {
+		return rec, err
+	}
+
+	switch kind {
+	case capKindAlert:
+		rec.Alert = &pb.Alert{}
+		err = proto.Unmarshal(payload, rec.Alert)
+	case capKindLog:
+		rec.Log = &pb.Log{}
+		err = proto.Unmarshal(payload, rec.Log)
+	case capKindMessage:
+		rec.Message = &pb.Message{}
+		err = proto.Unmarshal(payload, rec.Message)
+	default:
+		err = fmt.Errorf("unknown record kind %d", kind)
+	}
+	return rec, err
+}
+
+// walkFields calls fn for every varint and bytes field of b
+func walkFields(b []byte, fn func(num protowire.Number, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			data, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, 0, data)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func readUvarint(r io.ByteReader) (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		c, err := r.ReadByte()
+		if err != nil {
+			if shift > 0 && err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		x |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, errors.New("varint overflows 64 bits")
+}
+
+// ============ //
+// == Replay == //
+// ============ //
+
+// ParseReplaySpeed parses a replay speed such as "10x", "0.5" or "max"
+func ParseReplaySpeed(s string) (float64, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return 1, nil
+	}
+	if s == "max" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid replay speed %q", s)
+	}
+	return f, nil
+}
+
+// StartReplay feeds a capture through the filters and outputs of the live
+// pipeline, honouring the original timing scaled by Options.ReplaySpeed
+// (0 replays as fast as possible).
+func StartReplay(o Options) (err error) {
+	// #nosec
+	file, err := os.Open(filepath.Clean(o.Replay))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	cr, err := NewCaptureReader(file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.stderr(), "Replaying capture of %s taken %s by karmor %s\n",
+		orDash(cr.Header.Cluster), cr.Header.Timestamp.Format(time.RFC3339), orDash(cr.Header.Version))
+
+	if !o.valid() {
+		flag.PrintDefaults()
+		return nil
+	}
+	if err := o.prepare(); err != nil {
+		return err
+	}
+	defer o.release()
+	defer func() {
+		err = o.gate.result(err)
+	}()
+	stopDelivery, err := o.startDelivery()
+	if err != nil {
+		return err
+	}
+	defer stopDelivery()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), osSignals...)
+	defer cancel()
+	// --until applies to the recorded timestamps only
+	if d := o.timeout(); d > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	ctx, cancel = o.gate.watch(ctx)
+	defer cancel()
+	stop := ctx.Done()
+
+	var first, start time.Time
+	alerts := o.counters.get("Alert")
+	logs := o.counters.get("Log")
+	wantAlerts := o.watchAlerts()
+	wantLogs := o.watchLogs() && !o.treeOnlyLogs()
+
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read capture: %w", err)
+		}
+
+		// keep the recorded pace between events
+		if o.ReplaySpeed > 0 {
+			if first.IsZero() {
+				first, start = rec.Time, time.Now()
+			}
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / o.ReplaySpeed))
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(time.Until(due)):
+			}
+		} else {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+		}
+
+		ro := o
+		ro.clusterContext = rec.Context
+		switch {
+		case rec.Log != nil && o.tree != nil && o.treeOnlyLogs():
+			arr, _ := json.Marshal(rec.Log)
+			handleTelemetry(arr, "Log", ro)
+		case rec.Alert != nil && wantAlerts:
+			if !takeSlot(alerts, o.Limit) {
+				continue
+			}
+			arr, _ := json.Marshal(rec.Alert)
+			WatchTelemetryHelper(arr, "Alert", ro)
+		case rec.Log != nil && wantLogs:
+			if !takeSlot(logs, o.Limit) {
+				continue
+			}
+			arr, _ := json.Marshal(rec.Log)
+			WatchTelemetryHelper(arr, "Log", ro)
+		case rec.Message != nil && o.MsgPath != "none":
+			writeMessage(rec.Message, o.MsgPath, o.JSON)
+		}
+
+		if o.Limit > 0 && (!wantAlerts || alerts.Load() >= o.Limit) && (!wantLogs || logs.Load() >= o.Limit) {
+			return nil
+		}
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
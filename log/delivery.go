@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow policies of a Delivery, applied once its buffer is full
+const (
+	OverflowBlock      = "block"       // wait for the consumer, pausing the streams, until closed
+	OverflowDropOldest = "drop-oldest" // make room by dropping the oldest buffered event
+	OverflowDropNewest = "drop-newest" // drop the event being delivered
+	OverflowSample     = "sample"      // keep one in deliverySampleRate events, as drop-oldest
+)
+
+// OverflowPolicies are the accepted overflow policies
+var OverflowPolicies = []string{OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSample}
+
+const (
+	// DefaultDeliveryBuffer is the capacity of a Delivery if none is given
+	DefaultDeliveryBuffer = 1024
+
+	// deliverySampleRate is the share of the events kept while sampling
+	deliverySampleRate = 10
+
+	// deliveryWarnInterval is the least time between two warnings about
+	// dropped events
+	deliveryWarnInterval = 10 * time.Second
+
+	// deliveryFlushTimeout bounds the wait for the consumer once closed
+	deliveryFlushTimeout = time.Second
+
+	// deliveryBlockFlushTimeout is deliveryFlushTimeout with the block
+	// policy, it is longer since block is meant not to lose events
+	deliveryBlockFlushTimeout = 10 * time.Second
+)
+
+// Delivery hands events over to a consumer channel through a bounded buffer,
+// so that a slow consumer does not stall the gRPC streams. Once the buffer is
+// full, events are handled according to the overflow policy, and dropped
+// events are counted and reported periodically.
+type Delivery[T any] struct {
+	out    chan<- T
+	buf    chan T
+	policy string
+	warn   io.Writer
+	name   string
+
+	flushTimeout time.Duration // see Close
+
+	mu         sync.Mutex // serialises making room in buf
+	overflowed uint64     // events seen while buf was full, for sampling
+	dropped    atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// ValidOverflow checks an overflow policy, the empty one is block
+func ValidOverflow(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range OverflowPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown overflow policy %q, use one of %v", policy, OverflowPolicies)
+}
+
+// NewDelivery starts delivering to out the events sent, buffering up to size
+// of them. Dropped events are reported on warn, named name, unless it is nil.
+func NewDelivery[T any](out chan<- T, size int, policy, name string, warn io.Writer) (*Delivery[T], error) {
+	if err := ValidOverflow(policy); err != nil {
+		return nil, err
+	}
+	if policy == "" {
+		policy = OverflowBlock
+	}
+	if size <= 0 {
+		size = DefaultDeliveryBuffer
+	}
+	d := &Delivery[T]{
+		out:    out,
+		buf:    make(chan T, size),
+		policy: policy,
+		warn:   warn,
+		name:   name,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+
+		flushTimeout: deliveryFlushTimeout,
+	}
+	if policy == OverflowBlock {
+		d.flushTimeout = deliveryBlockFlushTimeout
+	}
+	go d.run()
+	return d, nil
+}
+
+// Send queues an event, it only waits for room with the block policy and
+// returns false if the delivery is closed meanwhile
+func (d *Delivery[T]) Send(v T) bool {
+	select {
+	case d.buf <- v:
+		return true
+	case <-d.stop:
+		return false
+	default:
+	}
+
+	switch d.policy {
+	case OverflowDropNewest:
+		d.dropped.Add(1)
+		return true
+	case OverflowDropOldest, OverflowSample:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.policy == OverflowSample {
+			d.overflowed++
+			if d.overflowed%deliverySampleRate != 1 {
+				d.dropped.Add(1)
+				return true
+			}
+		}
+		for {
+			select {
+			case d.buf <- v:
+				return true
+			default:
+			}
+			select {
+			case <-d.buf:
+				d.dropped.Add(1)
+			default:
+			}
+		}
+	}
+
+	select {
+	case d.buf <- v:
+		return true
+	case <-d.stop:
+		return false
+	}
+}
+
+// Dropped returns the number of events dropped so far
+func (d *Delivery[T]) Dropped() uint64 {
+	if d == nil {
+		return 0
+	}
+	return d.dropped.Load()
+}
+
+func (d *Delivery[T]) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(deliveryWarnInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case v := <-d.buf:
+			select {
+			case d.out <- v:
+			case <-d.stop:
+				d.flush(v)
+				return
+			}
+		case <-ticker.C:
+			reported = d.report(reported)
+		case <-d.stop:
+			d.flush()
+			return
+		}
+	}
+}
+
+// flush delivers the buffered events once closed, giving the consumer
+// flushTimeout at most. The events left are dropped.
+func (d *Delivery[T]) flush(pending ...T) {
+	timer := time.NewTimer(d.flushTimeout)
+	defer timer.Stop()
+	timeout := timer.C
+	expired := false
+	deliver := func(v T) {
+		if !expired {
+			select {
+			case d.out <- v:
+				return
+			case <-timeout:
+				expired = true
+			}
+		}
+		d.dropped.Add(1)
+	}
+	for _, v := range pending {
+		deliver(v)
+	}
+	for {
+		select {
+		case v := <-d.buf:
+			deliver(v)
+		default:
+			return
+		}
+	}
+}
+
+// report warns about the events dropped since the last report
+func (d *Delivery[T]) report(reported uint64) uint64 {
+	dropped := d.dropped.Load()
+	if d.warn != nil && dropped > reported {
+		fmt.Fprintf(d.warn, "Dropped %d %s for a slow consumer (overflow policy %s, %d in total)\n",
+			dropped-reported, d.name, d.policy, dropped)
+	}
+	return dropped
+}
+
+// Close stops delivering, once the buffered events are handed over. A
+// consumer that stopped reading is waited for deliveryFlushTimeout, or
+// deliveryBlockFlushTimeout with the block policy, at most; the events it did
+// not take by then are dropped and counted in Dropped. Events sent afterwards
+// are not delivered.
+func (d *Delivery[T]) Close() {
+	if d == nil {
+		return
+	}
+	d.once.Do(func() {
+		close(d.stop)
+		<-d.done
+		if d.warn != nil {
+			if n := d.dropped.Load(); n > 0 {
+				fmt.Fprintf(d.warn, "Dropped %d %s in total for a slow consumer\n", n, d.name)
+			}
+		}
+	})
+}
+
+// startDelivery puts a Delivery in front of EventChan and the channels of an
+// Observer if an Overflow policy is set, the returned function closes them
+func (o *Options) startDelivery() (func(), error) {
+	if o.Overflow == "" {
+		return func() {}, nil
+	}
+	var err error
+	if o.EventChan != nil {
+		if o.events, err = NewDelivery[EventInfo](o.EventChan, o.EventBuffer, o.Overflow, "events", o.stderr()); err != nil {
+			return nil, err
+		}
+	}
+	if o.alertChan != nil {
+		if o.alertQueue, err = NewDelivery(o.alertChan, o.EventBuffer, o.Overflow, "alerts", o.stderr()); err != nil {
+			o.events.Close()
+			return nil, err
+		}
+	}
+	if o.logChan != nil {
+		if o.logQueue, err = NewDelivery(o.logChan, o.EventBuffer, o.Overflow, "logs", o.stderr()); err != nil {
+			o.events.Close()
+			o.alertQueue.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		o.events.Close()
+		o.alertQueue.Close()
+		o.logQueue.Close()
+	}, nil
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// fillDelivery sends 1..n to a delivery of capacity size whose consumer only
+// reads once all are sent, and returns what it receives
+func fillDelivery(t *testing.T, policy string, size, n int) ([]int, uint64) {
+	t.Helper()
+	out := make(chan int)
+	d, err := NewDelivery(out, size, policy, "ints", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first event is held by the forwarder, blocked on out
+	d.Send(0)
+	for deadline := time.Now().Add(time.Second); len(d.buf) != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= n; i++ {
+		d.Send(i)
+	}
+
+	var got []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for v := range out {
+			got = append(got, v)
+		}
+	}()
+	d.Close()
+	close(out)
+	<-done
+	return got, d.Dropped()
+}
+
+func TestDeliveryOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		want    []int
+		dropped uint64
+	}{
+		{OverflowDropNewest, []int{0, 1, 2, 3}, 17},
+		{OverflowDropOldest, []int{0, 18, 19, 20}, 17},
+		{OverflowSample, []int{0, 3, 4, 14}, 17},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			got, dropped := fillDelivery(t, tc.policy, 3, 20)
+			if len(got) != len(tc.want) || dropped != tc.dropped {
+				t.Fatalf("expected %v with %d dropped, got %v with %d dropped", tc.want, tc.dropped, got, dropped)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("expected %v, got %v", tc.want, got)
+					break
+				}
+			}
+		})
+	}
+
+	// block keeps everything, waiting for the consumer
+	out := make(chan int, 100)
+	d, err := NewDelivery(out, 2, OverflowBlock, "ints", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		d.Send(i)
+	}
+	d.Close()
+	if len(out) != 50 || d.Dropped() != 0 {
+		t.Errorf("expected all 50 events with block, got %d with %d dropped", len(out), d.Dropped())
+	}
+
+	// block does not wait forever for a consumer that stopped reading
+	d, err = NewDelivery(make(chan int), 2, OverflowBlock, "ints", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.flushTimeout = 10 * time.Millisecond
+	d.Send(0)
+	d.Send(1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		d.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing waited for the stopped consumer")
+	}
+	if d.Dropped() != 2 {
+		t.Errorf("expected the 2 events left to be dropped, got %d", d.Dropped())
+	}
+
+	if _, err := NewDelivery(out, 2, "spill", "ints", nil); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestDeliveryWarning(t *testing.T) {
+	var warn bytes.Buffer
+	d, err := NewDelivery(make(chan int), 1, OverflowDropNewest, "alerts", &warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		d.Send(i)
+	}
+	reported := d.report(0)
+	d.Close()
+	if reported == 0 || !strings.Contains(warn.String(), "alerts for a slow consumer (overflow policy drop-newest") ||
+		!strings.Contains(warn.String(), "in total for a slow consumer") {
+		t.Errorf("unexpected warnings %q", warn.String())
+	}
+}
+
+// TestDeliveryObserver checks that a stalled consumer of EventChan does not
+// stall handling the events
+func TestDeliveryObserver(t *testing.T) {
+	events := make(chan EventInfo)
+	o := Options{LogPath: "none", Sinks: []string{}, LogFilter: "policy", EventChan: events,
+		Overflow: OverflowDropOldest, EventBuffer: 4}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := o.startDelivery()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		for i := 0; i < 100; i++ {
+			arr, _ := json.Marshal(&pb.Alert{PolicyName: "storm"})
+			handleTelemetry(arr, "Alert", o)
+		}
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handling the events stalled on the consumer")
+	}
+	if o.events.Dropped() == 0 {
+		t.Error("expected events to be dropped")
+	}
+	stop()
+	o.release()
+
+	if err := (&Options{Overflow: "spill"}).prepare(); err == nil {
+		t.Error("expected an error for an unknown overflow policy")
+	}
+}
@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	"github.com/kubearmor/kubearmor-client/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// forwardToPod port-forwards to the gRPC port of a KubeArmor pod, it returns
+// the local address and a function releasing the port forward
+var forwardToPod = func(c *k8s.Client, pod *corev1.Pod) (string, func(), error) {
+	pf, err := utils.InitiatePodPortForward(c, port, pod.Namespace, pod.Name)
+	if err != nil {
+		return "", func() {}, err
+	}
+	return "localhost:" + strconv.FormatInt(pf.LocalPort, 10), pf.Stop, nil
+}
+
+// nodeSelector matches the nodes given with Nodes, by name or glob pattern,
+// or by label selector if the entry has an = in it. Any entry matching is
+// enough, and all nodes match without entries.
+type nodeSelector struct {
+	names  []string
+	labels []labels.Selector
+}
+
+func newNodeSelector(nodes []string) (*nodeSelector, error) {
+	ns := &nodeSelector{}
+	for _, n := range nodes {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if strings.Contains(n, "=") {
+			sel, err := labels.Parse(n)
+			if err != nil {
+				return nil, fmt.Errorf("invalid node selector %q: %w", n, err)
+			}
+			ns.labels = append(ns.labels, sel)
+			continue
+		}
+		if _, err := path.Match(n, ""); err != nil {
+			return nil, fmt.Errorf("invalid node pattern %q: %w", n, err)
+		}
+		ns.names = append(ns.names, n)
+	}
+	return ns, nil
+}
+
+// match reports whether the node of the given name is selected, the labels
+// of the node are only looked up if needed
+func (ns *nodeSelector) match(ctx context.Context, c *k8s.Client, name string) (bool, error) {
+	if len(ns.names) == 0 && len(ns.labels) == 0 {
+		return true, nil
+	}
+	for _, n := range ns.names {
+		if ok, _ := path.Match(n, name); ok {
+			return true, nil
+		}
+	}
+	if len(ns.labels) == 0 {
+		return false, nil
+	}
+	node, err := c.K8sClientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get the node %s: %w", name, err)
+	}
+	for _, sel := range ns.labels {
+		if sel.Matches(labels.Set(node.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// observeDirect connects to every KubeArmor daemon of a cluster on the
+// selected nodes instead of the relay, and fans their streams in. Every event
+// carries the node it came from in its NodeName field. New and restarted
+// daemons are picked up while observing. It returns nil once interrupted or
+// --limit is reached.
+func observeDirect(c *k8s.Client, o Options) error {
+	if c == nil || c.K8sClientset == nil {
+		return errors.New("--direct needs a cluster to find the KubeArmor pods in")
+	}
+	selector, err := newNodeSelector(o.Nodes)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ds := &directSource{
+		client:    c,
+		o:         o,
+		selector:  selector,
+		cancel:    cancel,
+		following: map[string]bool{},
+	}
+
+	ticker := time.NewTicker(podSourceResync)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		n, err := ds.sync(ctx)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil && first:
+			return err
+		case err != nil:
+			// daemons already connected to go on meanwhile
+			fmt.Fprintln(o.stderr(), err.Error())
+		case n == 0 && first:
+			if len(o.Nodes) != 0 {
+				return fmt.Errorf("no running KubeArmor pods found with the label %s on the nodes %s",
+					kubearmorPodLabels, strings.Join(o.Nodes, ","))
+			}
+			return fmt.Errorf("no running KubeArmor pods found with the label %s", kubearmorPodLabels)
+		}
+
+		select {
+		case <-ctx.Done():
+			ds.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// directSource observes the KubeArmor daemons of a cluster
+type directSource struct {
+	client   *k8s.Client
+	o        Options
+	selector *nodeSelector
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	following map[string]bool // daemons observed, by pod UID
+}
+
+// sync observes the running KubeArmor pods of the selected nodes not observed
+// yet, it returns the number of those pods
+func (ds *directSource) sync(ctx context.Context) (int, error) {
+	pods, err := ds.client.K8sClientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: kubearmorPodLabels})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the KubeArmor pods: %w", err)
+	}
+
+	running := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.Spec.NodeName == "" {
+			continue
+		}
+		ok, err := ds.selector.match(ctx, ds.client, pod.Spec.NodeName)
+		if err != nil {
+			return running, err
+		}
+		if !ok {
+			continue
+		}
+		running++
+
+		uid := string(pod.UID)
+		ds.mu.Lock()
+		if ds.following[uid] {
+			ds.mu.Unlock()
+			continue
+		}
+		ds.following[uid] = true
+		ds.mu.Unlock()
+
+		ds.wg.Add(1)
+		go func() {
+			defer ds.wg.Done()
+			ds.observe(pod)
+		}()
+	}
+	return running, nil
+}
+
+// observe watches a daemon as a relay, reconnecting if the streams drop. If
+// it gives up, the pod is looked for again on the next sync.
+func (ds *directSource) observe(pod *corev1.Pod) {
+	uid := string(pod.UID)
+	defer func() {
+		ds.mu.Lock()
+		delete(ds.following, uid)
+		ds.mu.Unlock()
+	}()
+
+	o := ds.o
+	o.daemon = pod
+	fmt.Fprintf(o.stderr(), "Connecting to %s/%s on the node %s\n", pod.Namespace, pod.Name, pod.Spec.NodeName)
+	if err := observeCluster(ds.client, o); err != nil {
+		fmt.Fprintf(o.stderr(), "Failed to observe the node %s (%s)\n", pod.Spec.NodeName, err.Error())
+		return
+	}
+	// interrupted or --limit reached
+	ds.cancel()
+}
+
+// withNodeName adds the NodeName field to a JSON encoded event
+func withNodeName(arr []byte, node string) []byte {
+	return withField(arr, "NodeName", node)
+}
@@ -0,0 +1,137 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func kubearmorNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestNodeSelector(t *testing.T) {
+	client := &k8s.Client{K8sClientset: fake.NewSimpleClientset(
+		kubearmorNode("worker-1", map[string]string{"pool": "gpu"}),
+		kubearmorNode("infra-1", map[string]string{"pool": "infra"}),
+		kubearmorNode("infra-2", nil),
+	)}
+	for _, tc := range []struct {
+		nodes []string
+		want  map[string]bool
+	}{
+		{nil, map[string]bool{"worker-1": true, "infra-1": true, "infra-2": true}},
+		{[]string{"infra-2"}, map[string]bool{"infra-2": true}},
+		{[]string{"infra-*"}, map[string]bool{"infra-1": true, "infra-2": true}},
+		{[]string{"pool=gpu", "infra-2"}, map[string]bool{"worker-1": true, "infra-2": true}},
+		{[]string{"pool!=gpu"}, map[string]bool{"infra-1": true, "infra-2": true}},
+	} {
+		ns, err := newNodeSelector(tc.nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range []string{"worker-1", "infra-1", "infra-2"} {
+			ok, err := ns.match(context.Background(), client, node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.want[node] {
+				t.Errorf("%v: expected %s to match %v, got %v", tc.nodes, node, tc.want[node], ok)
+			}
+		}
+	}
+
+	if _, err := newNodeSelector([]string{"pool=@gpu"}); err == nil {
+		t.Error("expected an error for an invalid label selector")
+	}
+	if _, err := newNodeSelector([]string{"worker-["}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestObserveDirect(t *testing.T) {
+	addrs := map[string]string{
+		"kubearmor-a": startFakeLogServer(t, &fakeLogServer{perStream: 5}),
+		"kubearmor-b": startFakeLogServer(t, &fakeLogServer{perStream: 5}),
+		"kubearmor-c": startFakeLogServer(t, &fakeLogServer{perStream: 5}),
+	}
+	var mu sync.Mutex
+	forwarded := map[string]bool{}
+	prev := forwardToPod
+	forwardToPod = func(_ *k8s.Client, pod *corev1.Pod) (string, func(), error) {
+		mu.Lock()
+		forwarded[pod.Name] = true
+		mu.Unlock()
+		return addrs[pod.Name], func() {}, nil
+	}
+	t.Cleanup(func() {
+		forwardToPod = prev
+	})
+
+	onNode := func(pod *corev1.Pod, node string) *corev1.Pod {
+		pod.Spec.NodeName = node
+		return pod
+	}
+	client := &k8s.Client{K8sClientset: fake.NewSimpleClientset(
+		onNode(kubearmorPod("kubearmor-a", corev1.PodRunning), "node-a"),
+		onNode(kubearmorPod("kubearmor-b", corev1.PodRunning), "node-b"),
+		onNode(kubearmorPod("kubearmor-c", corev1.PodRunning), "infra"),
+	)}
+
+	events := make(chan EventInfo, 20)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- startObservers(context.Background(), []clusterTarget{{client: client}}, Options{
+			Direct:    true,
+			Nodes:     []string{"node-*"},
+			MsgPath:   "none",
+			LogFilter: "policy",
+			Limit:     4,
+			EventChan: events,
+		})
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the direct observers did not stop at the limit")
+	}
+
+	if len(events) != 4 {
+		t.Errorf("expected 4 alerts, got %d", len(events))
+	}
+	for len(events) > 0 {
+		ev := <-events
+		var res map[string]interface{}
+		if err := json.Unmarshal(ev.Data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if node := res["NodeName"]; node != "node-a" && node != "node-b" {
+			t.Errorf("unexpected NodeName in %s", ev.Data)
+		}
+	}
+	mu.Lock()
+	if !forwarded["kubearmor-a"] || !forwarded["kubearmor-b"] || forwarded["kubearmor-c"] {
+		t.Errorf("expected to connect to the daemons of node-a and node-b, got %v", forwarded)
+	}
+	mu.Unlock()
+
+	err := startObservers(context.Background(), []clusterTarget{{client: client}},
+		Options{Direct: true, Nodes: []string{"gpu-*"}, MsgPath: "none", LogFilter: "policy", EventChan: events})
+	if err == nil || !strings.Contains(err.Error(), "on the nodes gpu-*") {
+		t.Errorf("expected an error without KubeArmor pods on the nodes, got %v", err)
+	}
+	if err := (&Options{Nodes: []string{"node-a"}}).prepare(); err == nil {
+		t.Error("expected an error for --nodes without --direct")
+	}
+}
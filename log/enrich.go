@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	kspAPI "github.com/kubearmor/KubeArmor/pkg/KubeArmorController/api/security.kubearmor.com/v1"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// enrichSyncTimeout bounds the wait for the initial listing of the policies
+// and pods, alerts are enriched with what is known once it passes
+const enrichSyncTimeout = 30 * time.Second
+
+// Enricher adds the definition of the matched policy and the owner of the
+// pod to alerts, from informer caches of the cluster
+type Enricher struct {
+	policies     cache.SharedIndexInformer
+	hostPolicies cache.SharedIndexInformer
+	pods         cache.SharedIndexInformer
+	replicaSets  cache.SharedIndexInformer
+	factory      informers.SharedInformerFactory
+
+	warn io.Writer // os.Stderr if nil
+}
+
+// NewEnricher creates the informers of the enricher, limited to namespace
+// if it is not empty. They run once Start is called.
+func NewEnricher(c *k8s.Client, namespace string) *Enricher {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.K8sClientset, 0, informers.WithNamespace(namespace))
+	return &Enricher{
+		policies: cache.NewSharedIndexInformer(cache.ToListWatcherWithWatchListSemantics(&cache.ListWatch{
+			ListWithContextFunc: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.KSPClientset.KubeArmorPolicies(namespace).List(ctx, opts)
+			},
+			WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.KSPClientset.KubeArmorPolicies(namespace).Watch(ctx, opts)
+			},
+		}, c.KSPClientset), &kspAPI.KubeArmorPolicy{}, 0, cache.Indexers{}),
+		hostPolicies: cache.NewSharedIndexInformer(cache.ToListWatcherWithWatchListSemantics(&cache.ListWatch{
+			ListWithContextFunc: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.KSPClientset.KubeArmorHostPolicies().List(ctx, opts)
+			},
+			WatchFuncWithContext: func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.KSPClientset.KubeArmorHostPolicies().Watch(ctx, opts)
+			},
+		}, c.KSPClientset), &kspAPI.KubeArmorHostPolicy{}, 0, cache.Indexers{}),
+		pods:        factory.Core().V1().Pods().Informer(),
+		replicaSets: factory.Apps().V1().ReplicaSets().Informer(),
+		factory:     factory,
+	}
+}
+
+// Start runs the informers until stop is closed and waits for their
+// initial listing, or enrichSyncTimeout
+func (e *Enricher) Start(stop <-chan struct{}) {
+	go e.policies.Run(stop)
+	go e.hostPolicies.Run(stop)
+	e.factory.Start(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrichSyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), e.policies.HasSynced, e.hostPolicies.HasSynced,
+		e.pods.HasSynced, e.replicaSets.HasSynced) {
+		warn := e.warn
+		if warn == nil {
+			warn = os.Stderr
+		}
+		fmt.Fprintln(warn, "Policies and pods are not fully listed yet, alerts may miss their enrichment")
+	}
+}
+
+// Enrich returns the fields to add to an alert: PolicyAction, PolicyMessage
+// and PolicyTags of the matched policy, and the Workload owning the pod. It
+// is not named Owner, which KubeArmor already sets to the owner reference.
+func (e *Enricher) Enrich(res map[string]interface{}) map[string]string {
+	fields := map[string]string{}
+
+	var spec struct {
+		action  string
+		message string
+		tags    []string
+	}
+	found := false
+	name := fieldString(res, "PolicyName")
+	if strings.HasPrefix(fieldString(res, "Type"), "MatchedHost") {
+		if obj, ok, _ := e.hostPolicies.GetStore().GetByKey(name); ok {
+			p := obj.(*kspAPI.KubeArmorHostPolicy)
+			spec.action, spec.message, spec.tags = string(p.Spec.Action), p.Spec.Message, p.Spec.Tags
+			found = true
+		}
+	} else if obj, ok, _ := e.policies.GetStore().GetByKey(fieldString(res, "NamespaceName") + "/" + name); ok {
+		p := obj.(*kspAPI.KubeArmorPolicy)
+		spec.action, spec.message, spec.tags = string(p.Spec.Action), p.Spec.Message, p.Spec.Tags
+		found = true
+	}
+	if found {
+		fields["PolicyAction"] = spec.action
+		fields["PolicyMessage"] = spec.message
+		fields["PolicyTags"] = strings.Join(spec.tags, ",")
+	}
+
+	if owner := e.owner(fieldString(res, "NamespaceName"), fieldString(res, "PodName")); owner != "" {
+		fields["Workload"] = owner
+	}
+	return fields
+}
+
+// owner returns the workload owning a pod as Kind/Name, following
+// ReplicaSets up to their Deployment
+func (e *Enricher) owner(namespace, pod string) string {
+	if pod == "" {
+		return ""
+	}
+	obj, ok, _ := e.pods.GetStore().GetByKey(namespace + "/" + pod)
+	if !ok {
+		return ""
+	}
+	ref := metav1.GetControllerOf(obj.(*corev1.Pod))
+	if ref == nil {
+		return ""
+	}
+	if ref.Kind == "ReplicaSet" {
+		if obj, ok, _ := e.replicaSets.GetStore().GetByKey(namespace + "/" + ref.Name); ok {
+			if rsRef := metav1.GetControllerOf(obj.(*appsv1.ReplicaSet)); rsRef != nil {
+				ref = rsRef
+			}
+		}
+	}
+	return ref.Kind + "/" + ref.Name
+}
+
+// enrichFields are the fields added by the enricher
+var enrichFields = []string{"PolicyAction", "PolicyMessage", "PolicyTags", "Workload"}
+
+// enrich adds the fields of the enricher to an alert
+func (o Options) enrich(arr []byte, res map[string]interface{}) []byte {
+	fields := o.enricher.Enrich(res)
+	for i := len(enrichFields) - 1; i >= 0; i-- {
+		k := enrichFields[i]
+		v := fields[k]
+		if v == "" {
+			continue
+		}
+		arr = withField(arr, k, v)
+		res[k] = v
+	}
+	return arr
+}
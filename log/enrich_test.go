@@ -0,0 +1,107 @@
+package log
+
+import (
+	"encoding/json"
+	"testing"
+
+	kspAPI "github.com/kubearmor/KubeArmor/pkg/KubeArmorController/api/security.kubearmor.com/v1"
+	kspfake "github.com/kubearmor/KubeArmor/pkg/KubeArmorController/client/clientset/versioned/fake"
+	ksp "github.com/kubearmor/KubeArmor/pkg/KubeArmorController/client/clientset/versioned/typed/security.kubearmor.com/v1"
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeSecurityV1 is a fake KubeArmor client without the WatchList semantics
+// of the API server, as the fake clientsets of client-go
+type fakeSecurityV1 struct {
+	ksp.SecurityV1Interface
+}
+
+func (fakeSecurityV1) IsWatchListSemanticsUnSupported() bool { return true }
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestEnrich(t *testing.T) {
+	c := &k8s.Client{
+		K8sClientset: fake.NewSimpleClientset(
+			&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-7f9",
+				OwnerReferences: controllerRef("Deployment", "web")}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-7f9-abcde",
+				OwnerReferences: controllerRef("ReplicaSet", "web-7f9")}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db-0",
+				OwnerReferences: controllerRef("StatefulSet", "db")}},
+		),
+		KSPClientset: fakeSecurityV1{kspfake.NewSimpleClientset(
+			&kspAPI.KubeArmorPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "block-shadow"},
+				Spec: kspAPI.KubeArmorPolicySpec{Action: "Block", Message: "shadow read", Tags: []string{"MITRE", "T1003"}}},
+			&kspAPI.KubeArmorHostPolicy{ObjectMeta: metav1.ObjectMeta{Name: "host-audit"},
+				Spec: kspAPI.KubeArmorHostPolicySpec{Action: "Audit"}},
+		).SecurityV1()},
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	e := NewEnricher(c, "")
+	e.Start(stop)
+
+	events := make(chan EventInfo, 10)
+	o := Options{LogPath: "none", Sinks: []string{}, LogFilter: "policy", EventChan: events,
+		Filter: "Workload == Deployment/web"}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.release()
+	o.enricher = e
+
+	for _, a := range []*pb.Alert{
+		{Type: "MatchedPolicy", NamespaceName: "prod", PodName: "web-7f9-abcde", PolicyName: "block-shadow",
+			Owner: &pb.Podowner{Ref: "Deployment", Name: "web", Namespace: "prod"}},
+		{Type: "MatchedPolicy", NamespaceName: "prod", PodName: "db-0", PolicyName: "block-shadow"},
+	} {
+		arr, _ := json.Marshal(a)
+		handleTelemetry(arr, "Alert", o)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected the alert of the deployment only, got %d", len(events))
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal((<-events).Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["PolicyAction"] != "Block" || res["PolicyMessage"] != "shadow read" || res["PolicyTags"] != "MITRE,T1003" {
+		t.Errorf("unexpected policy fields %v", res)
+	}
+	if res["Workload"] != "Deployment/web" {
+		t.Errorf("expected the Workload of the pod, got %v", res["Workload"])
+	}
+	if owner, ok := res["Owner"].(map[string]interface{}); !ok || owner["Name"] != "web" {
+		t.Errorf("expected the Owner of KubeArmor to be kept, got %v", res["Owner"])
+	}
+
+	for _, tc := range []struct {
+		alert *pb.Alert
+		want  map[string]string
+	}{
+		{&pb.Alert{Type: "MatchedPolicy", NamespaceName: "prod", PodName: "db-0", PolicyName: "missing"},
+			map[string]string{"Workload": "StatefulSet/db"}},
+		{&pb.Alert{Type: "MatchedHostPolicy", PolicyName: "host-audit"},
+			map[string]string{"PolicyAction": "Audit", "PolicyMessage": "", "PolicyTags": ""}},
+	} {
+		got := e.Enrich(toFieldMap(tc.alert))
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.alert.PolicyName, got, tc.want)
+			continue
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s: got %v, want %v", tc.alert.PolicyName, got, tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// fileTailPoll is how often a followed file is checked for new lines,
+	// rotation and truncation
+	fileTailPoll = 250 * time.Millisecond
+
+	// fileReadSize is the size of the reads of a followed file
+	fileReadSize = 64 * 1024
+)
+
+// observeFiles reads the alerts and logs KubeArmor writes to its log files,
+// e.g. the systemd KubeArmor of a VM, instead of connecting to it. The JSON
+// lines are handled as the ones of the relay. With Follow, the files are
+// followed as tail -F does, through rotation and truncation, starting at their
+// end unless Since is given. It returns nil once the files are read,
+// interrupted or --limit is reached.
+func observeFiles(o Options) error {
+	if o.MsgPath != "none" {
+		fmt.Fprintln(o.stderr(), "Messages are only streamed over gRPC, ignoring --msgPath with --file")
+	}
+	if !o.watchAlerts() && !o.watchLogs() {
+		return nil
+	}
+
+	tails := make([]*fileTail, 0, len(o.Files))
+	defer func() {
+		for _, ft := range tails {
+			ft.close()
+		}
+	}()
+	for _, path := range o.Files {
+		ft := &fileTail{path: path, o: o}
+		if err := ft.open(o.Follow && o.Since.IsZero()); err != nil {
+			return err
+		}
+		tails = append(tails, ft)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(tails))
+	for i, ft := range tails {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped, err := ft.run(ctx)
+			if stopped {
+				cancel()
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// fileTail reads the lines of a file, following it with Follow
+type fileTail struct {
+	path string
+	o    Options
+
+	file    *os.File
+	info    os.FileInfo
+	offset  int64  // bytes of file read so far
+	pending []byte // partial last line, until its end is written
+}
+
+// open opens the file at path, at its end if fromEnd
+func (ft *fileTail) open(fromEnd bool) error {
+	// #nosec
+	file, err := os.Open(filepath.Clean(ft.path))
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	var offset int64
+	if fromEnd {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	ft.file, ft.info, ft.offset, ft.pending = file, info, offset, nil
+	return nil
+}
+
+func (ft *fileTail) close() {
+	if ft.file != nil {
+		_ = ft.file.Close()
+		ft.file = nil
+	}
+}
+
+// run handles the lines of the file until its end, or until ctx is done with
+// Follow. It returns true if stopped or --limit is reached.
+func (ft *fileTail) run(ctx context.Context) (bool, error) {
+	for {
+		ok, err := ft.read()
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", ft.path, err)
+		}
+		if !ok {
+			return true, nil
+		}
+		if !ft.o.Follow {
+			// the last line may not end with a newline
+			if len(ft.pending) != 0 && !ft.o.handleLine(ft.pending) {
+				return true, nil
+			}
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(fileTailPoll):
+		}
+		if ok, err := ft.reopen(); err != nil || !ok {
+			return !ok, err
+		}
+	}
+}
+
+// read handles the lines written to the file since the last read, it returns
+// false if stopped or --limit is reached
+func (ft *fileTail) read() (bool, error) {
+	buf := make([]byte, fileReadSize)
+	for {
+		n, err := ft.file.Read(buf)
+		if n > 0 {
+			ft.offset += int64(n)
+			if !ft.lines(buf[:n]) {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// lines handles the complete lines of data, keeping the partial last one
+func (ft *fileTail) lines(data []byte) bool {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			ft.pending = append(ft.pending, data...)
+			if len(ft.pending) > podLogMaxLine {
+				// not a line of KubeArmor
+				ft.pending = ft.pending[:0]
+			}
+			return true
+		}
+		line := data[:i]
+		if len(ft.pending) != 0 {
+			line = append(ft.pending, line...)
+			ft.pending = ft.pending[:0]
+		}
+		data = data[i+1:]
+		if !ft.o.handleLine(line) {
+			return false
+		}
+	}
+}
+
+// reopen follows the file at path again if it was rotated, once the rest of
+// the rotated file is read, and reads a truncated file from its start. It
+// returns false if stopped or --limit is reached.
+func (ft *fileTail) reopen() (bool, error) {
+	info, err := os.Stat(ft.path)
+	if err != nil {
+		// rotated away, the new file is not created yet
+		return true, nil
+	}
+
+	if !os.SameFile(info, ft.info) {
+		if ok, err := ft.read(); err != nil || !ok {
+			return ok, err
+		}
+		rotated := ft.file
+		if err := ft.open(false); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// rotated away again meanwhile
+				return true, nil
+			}
+			return true, err
+		}
+		_ = rotated.Close()
+		fmt.Fprintf(ft.o.stderr(), "%s was rotated, following the new file\n", ft.path)
+		return true, nil
+	}
+
+	if info.Size() < ft.offset {
+		if _, err := ft.file.Seek(0, io.SeekStart); err != nil {
+			return true, err
+		}
+		ft.offset, ft.pending = 0, nil
+		fmt.Fprintf(ft.o.stderr(), "%s was truncated, reading it from the start\n", ft.path)
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObserveFiles(t *testing.T) {
+	dir := t.TempDir()
+	alerts := filepath.Join(dir, "alerts.json")
+	logs := filepath.Join(dir, "logs.json")
+	appendFile(t, alerts, `{"Timestamp":1,"HostName":"vm-1","Type":"MatchedHostPolicy","PolicyName":"block-shadow","Action":"Block"}
+not json {
+{"Timestamp":2,"HostName":"vm-1","Type":"MatchedHostPolicy","PolicyName":"audit-curl","Action":"Audit"}`)
+	appendFile(t, logs, `{"Timestamp":3,"HostName":"vm-1","Type":"HostLog","Operation":"File","Resource":"/etc/hosts"}
+`)
+
+	events := make(chan EventInfo, 10)
+	if err := StartObserver(nil, Options{
+		Files:     []string{alerts, logs},
+		MsgPath:   "none",
+		LogFilter: "all",
+		Filter:    "Action!=Block",
+		EventChan: events,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for len(events) > 0 {
+		ev := <-events
+		counts[ev.Type]++
+	}
+	// the last line is handled without a newline
+	if counts["Alert"] != 1 || counts["Log"] != 1 {
+		t.Errorf("expected 1 alert and 1 log, got %v", counts)
+	}
+
+	err := StartObserver(nil, Options{Files: []string{filepath.Join(dir, "missing.json")}, MsgPath: "none", LogFilter: "policy"})
+	if !os.IsNotExist(err) {
+		t.Errorf("expected an error for a missing file, got %v", err)
+	}
+}
+
+func TestObserveFilesFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	alert := func(policy string) string {
+		return `{"Timestamp":1,"Type":"MatchedHostPolicy","PolicyName":"` + policy + `"}` + "\n"
+	}
+	appendFile(t, path, alert("before-start"))
+
+	events := make(chan EventInfo, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- StartObserver(nil, Options{
+			Files:     []string{path},
+			Follow:    true,
+			MsgPath:   "none",
+			LogFilter: "policy",
+			Limit:     4,
+			EventChan: events,
+		})
+	}()
+	next := func(want string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			var res map[string]interface{}
+			if err := json.Unmarshal(ev.Data, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res["PolicyName"] != want {
+				t.Fatalf("expected %s, got %s", want, ev.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was not followed", want)
+		}
+	}
+
+	// the file is followed from its end, give the tail time to open it
+	time.Sleep(2 * fileTailPoll)
+	appendFile(t, path, alert("appended"))
+	next("appended")
+
+	// the rest of the rotated file is read before the new one
+	appendFile(t, path, alert("written-before-rotating"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, alert("rotated"))
+	next("written-before-rotating")
+	next("rotated")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * fileTailPoll)
+	appendFile(t, path, alert("truncated"))
+	next("truncated")
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("following did not stop at the limit")
+	}
+}
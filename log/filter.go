@@ -0,0 +1,732 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Filter is a compiled boolean expression evaluated against alerts and logs.
+//
+// The expression language supports:
+//
+//	Field == value, Field != value       exact comparison
+//	Field =~ regex, Field !~ regex       regular expression match
+//	Field > n, >=, <, <=                 numeric (or lexical) comparison
+//	Field in (a, b, 1..5)                membership, with numeric ranges
+//	expr AND expr, expr OR expr, NOT expr, ( expr )
+//
+// Keywords are case-insensitive and &&, || and ! may be used in place of
+// AND, OR and NOT. Field names are the fields of pb.Alert and pb.Log;
+// nested fields are addressed with dots, e.g. Owner.Name or EventData.key.
+// Values containing spaces or operator characters must be quoted.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// filterNode is a single node of the compiled expression tree
+type filterNode interface {
+	eval(res map[string]interface{}) bool
+}
+
+// CompileFilter parses the given filter expression
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{}
+	if err := p.tokenize(expr); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 1 {
+		// empty expression matches everything
+		return &Filter{expr: expr, root: trueNode{}}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether the event satisfies the filter
+func (f *Filter) Match(res map[string]interface{}) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.eval(res)
+}
+
+// String returns the source expression of the filter
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// NewOptionsFilter builds a single filter from the --filter expression and
+// the legacy per-field flags (--namespace, --operation, --labels, ...), all
+// of which are ANDed together.
+func NewOptionsFilter(o Options) (*Filter, error) {
+	var nodes []filterNode
+	var exprs []string
+
+	if len(o.Selector) != 0 {
+		sel, err := ParseLabelSelector(o.Selector)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, labelsNode{selector: sel})
+		exprs = append(exprs, fmt.Sprintf("Labels selected by (%s)", sel.String()))
+	}
+
+	legacy := []struct {
+		field, pattern string
+		ignoreCase     bool
+	}{
+		{"NamespaceName", o.Namespace, true},
+		{"Type", o.LogType, true},
+		{"Operation", o.Operation, true},
+		{"ContainerName", o.ContainerName, true},
+		{"PodName", o.PodName, true},
+		{"Source", o.Source, false},
+		{"Resource", o.Resource, false},
+	}
+	for _, l := range legacy {
+		if l.pattern == "" {
+			continue
+		}
+		pattern := l.pattern
+		if l.ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &compareNode{field: []string{l.field}, op: "=~", re: re, required: true})
+		exprs = append(exprs, fmt.Sprintf("%s =~ %q", l.field, pattern))
+	}
+
+	// time window on the event Timestamp, in seconds since the epoch
+	for _, w := range []struct {
+		op string
+		t  time.Time
+	}{{">=", o.Since}, {"<=", o.Until}} {
+		if w.t.IsZero() {
+			continue
+		}
+		sec := w.t.Unix()
+		nodes = append(nodes, &compareNode{field: []string{"Timestamp"}, op: w.op,
+			value: strconv.FormatInt(sec, 10), num: float64(sec), isNum: true, required: true})
+		exprs = append(exprs, fmt.Sprintf("Timestamp %s %d", w.op, sec))
+	}
+
+	if strings.TrimSpace(o.Filter) != "" {
+		f, err := CompileFilter(o.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter expression: %w", err)
+		}
+		nodes = append(nodes, f.root)
+		exprs = append(exprs, "("+o.Filter+")")
+	}
+
+	switch len(nodes) {
+	case 0:
+		return &Filter{root: trueNode{}}, nil
+	case 1:
+		return &Filter{expr: exprs[0], root: nodes[0]}, nil
+	}
+	return &Filter{expr: strings.Join(exprs, " AND "), root: andNode(nodes)}, nil
+}
+
+// ParseTime parses the value of --since or --until, either a RFC3339 time
+// or a duration before now such as 10m
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a RFC3339 time or a duration such as 10m", s)
+	}
+	return t, nil
+}
+
+// ParseDeadline parses the value of --until for a live run, either a RFC3339
+// time or a duration from now such as 10m
+func ParseDeadline(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil {
+		return now.Add(d), nil
+	}
+	return ParseTime(s, now)
+}
+
+// ================= //
+// == Expressions == //
+// ================= //
+
+type trueNode struct{}
+
+func (trueNode) eval(map[string]interface{}) bool { return true }
+
+type andNode []filterNode
+
+func (n andNode) eval(res map[string]interface{}) bool {
+	for _, c := range n {
+		if !c.eval(res) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []filterNode
+
+func (n orNode) eval(res map[string]interface{}) bool {
+	for _, c := range n {
+		if c.eval(res) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	child filterNode
+}
+
+func (n notNode) eval(res map[string]interface{}) bool {
+	return !n.child.eval(res)
+}
+
+// labelsNode matches the Labels of the event against the label selector of
+// the --labels flag. Events without labels are not filtered.
+type labelsNode struct {
+	selector labels.Selector
+}
+
+func (n labelsNode) eval(res map[string]interface{}) bool {
+	l, ok := res["Labels"].(string)
+	if !ok {
+		return true
+	}
+	return MatchLabels(n.selector, l)
+}
+
+// ParseLabelSelector parses the terms of a --labels flag as a Kubernetes
+// label selector, e.g. "env in (prod,staging)", "!canary", "tier!=frontend",
+// all of which must match. The terms are joined back with commas as the flag
+// splits them, including within "in (...)" lists.
+func ParseLabelSelector(terms []string) (labels.Selector, error) {
+	sel, err := labels.Parse(strings.Join(terms, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return sel, nil
+}
+
+// MatchLabels reports whether the comma separated key=value Labels of an
+// event match the selector
+func MatchLabels(sel labels.Selector, eventLabels string) bool {
+	set := labels.Set{}
+	for _, kv := range strings.Split(eventLabels, ",") {
+		if kv == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		set[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return sel.Matches(set)
+}
+
+// inValue is a single member of an `in` list
+type inValue struct {
+	str      string
+	num      float64
+	isNum    bool
+	isRange  bool
+	from, to float64
+}
+
+// compareNode compares a field of the event against a value
+type compareNode struct {
+	field []string
+	op    string
+	value string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+	list  []inValue
+
+	// required makes the node fail when the field is missing entirely,
+	// matching the behaviour of the legacy per-field flags
+	required bool
+}
+
+func (n *compareNode) eval(res map[string]interface{}) bool {
+	vals, found := lookupField(res, n.field)
+	if !found {
+		if n.required {
+			return false
+		}
+		// missing fields compare as empty strings
+		vals = []interface{}{""}
+	}
+	for _, v := range vals {
+		if n.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *compareNode) match(v interface{}) bool {
+	s := valueString(v)
+	switch n.op {
+	case "==":
+		if f, ok := valueNumber(v); ok && n.isNum {
+			return f == n.num
+		}
+		return s == n.value
+	case "!=":
+		if f, ok := valueNumber(v); ok && n.isNum {
+			return f != n.num
+		}
+		return s != n.value
+	case "=~":
+		return n.re.MatchString(s)
+	case "!~":
+		return !n.re.MatchString(s)
+	case ">", ">=", "<", "<=":
+		var c int
+		if f, ok := valueNumber(v); ok && n.isNum {
+			switch {
+			case f < n.num:
+				c = -1
+			case f > n.num:
+				c = 1
+			}
+		} else {
+			c = strings.Compare(s, n.value)
+		}
+		switch n.op {
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		case "<":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	case "in":
+		f, isNum := valueNumber(v)
+		for _, item := range n.list {
+			switch {
+			case item.isRange:
+				if isNum && f >= item.from && f <= item.to {
+					return true
+				}
+			case item.isNum && isNum:
+				if f == item.num {
+					return true
+				}
+			default:
+				if s == item.str {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// lookupField resolves a (possibly dotted) field path. Slices yield each of
+// their elements so that e.g. `ATags == MITRE` matches any tag.
+func lookupField(res map[string]interface{}, path []string) ([]interface{}, bool) {
+	var cur interface{} = res
+	for _, p := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	if arr, ok := cur.([]interface{}); ok {
+		return arr, true
+	}
+	return []interface{}{cur}, true
+}
+
+func valueString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+func valueNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// ============ //
+// == Fields == //
+// ============ //
+
+// filterFields maps lower-cased field names to the canonical JSON key
+var filterFields = func() map[string]string {
+	fields := map[string]string{}
+	for _, t := range []reflect.Type{reflect.TypeOf(pb.Alert{}), reflect.TypeOf(pb.Log{})} {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = f.Name
+			}
+			fields[strings.ToLower(name)] = name
+		}
+	}
+	for _, name := range extraEventFields {
+		fields[strings.ToLower(name)] = name
+	}
+	return fields
+}()
+
+// extraEventFields are added to events by karmor itself
+var extraEventFields = []string{
+	"ClusterContext",
+	"NodeName",
+	"ProcessTree",
+	"PolicyAction",
+	"PolicyMessage",
+	"PolicyTags",
+	"Workload",
+}
+
+// resolveField validates a field path and returns it in canonical form
+func resolveField(name string) ([]string, error) {
+	path := strings.Split(name, ".")
+	canonical, ok := filterFields[strings.ToLower(path[0])]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+	path[0] = canonical
+	return path, nil
+}
+
+// ============ //
+// == Parser == //
+// ============ //
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type filterParser struct {
+	tokens []token
+	cur    int
+}
+
+func (p *filterParser) tokenize(s string) error {
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			p.tokens = append(p.tokens, token{tokComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(rs) && rs[i] != r; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) && (rs[i+1] == r || rs[i+1] == '\\') {
+					i++
+				}
+				sb.WriteRune(rs[i])
+			}
+			if i >= len(rs) {
+				return fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			p.tokens = append(p.tokens, token{tokString, sb.String(), start})
+		case strings.ContainsRune("=!<>&|", r):
+			two := ""
+			if i+1 < len(rs) {
+				two = string(rs[i : i+2])
+			}
+			switch two {
+			case "==", "!=", "=~", "!~", ">=", "<=", "&&", "||":
+				p.tokens = append(p.tokens, token{tokOp, two, i})
+				i += 2
+				continue
+			}
+			switch r {
+			case '>', '<', '!':
+				p.tokens = append(p.tokens, token{tokOp, string(r), i})
+				i++
+			case '=':
+				// accept a single = as equality
+				p.tokens = append(p.tokens, token{tokOp, "==", i})
+				i++
+			default:
+				return fmt.Errorf("unexpected %q at offset %d", r, i)
+			}
+		default:
+			start := i
+			for i < len(rs) && !unicode.IsSpace(rs[i]) && !strings.ContainsRune("(),\"'=!<>&|", rs[i]) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokWord, string(rs[start:i]), start})
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "end of expression", len(rs)})
+	return nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.cur]
+	if tok.kind != tokEOF {
+		p.cur++
+	}
+	return tok
+}
+
+func (p *filterParser) isKeyword(tok token, words ...string) bool {
+	if tok.kind == tokOp {
+		for _, w := range words {
+			if tok.text == w {
+				return true
+			}
+		}
+		return false
+	}
+	if tok.kind != tokWord {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(tok.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := orNode{left}
+	for p.isKeyword(p.peek(), "OR", "||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	nodes := andNode{left}
+	for p.isKeyword(p.peek(), "AND", "&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return nodes, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.isKeyword(p.peek(), "NOT", "!") {
+		p.next()
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child: child}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at offset %d, got %q", closing.pos, closing.text)
+		}
+		return n, nil
+	case tokWord:
+		return p.parseComparison(tok)
+	}
+	return nil, fmt.Errorf("expected field name at offset %d, got %q", tok.pos, tok.text)
+}
+
+func (p *filterParser) parseComparison(fieldTok token) (filterNode, error) {
+	path, err := resolveField(fieldTok.text)
+	if err != nil {
+		return nil, err
+	}
+	n := &compareNode{field: path}
+
+	opTok := p.next()
+	switch {
+	case p.isKeyword(opTok, "in"):
+		n.op = "in"
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		n.list = list
+		return n, nil
+	case opTok.kind == tokOp && opTok.text != "&&" && opTok.text != "||" && opTok.text != "!":
+		n.op = opTok.text
+	default:
+		return nil, fmt.Errorf("expected operator after %s at offset %d, got %q", fieldTok.text, opTok.pos, opTok.text)
+	}
+
+	valTok := p.next()
+	if valTok.kind != tokWord && valTok.kind != tokString {
+		return nil, fmt.Errorf("expected value for %s at offset %d, got %q", fieldTok.text, valTok.pos, valTok.text)
+	}
+	n.value = valTok.text
+	if valTok.kind == tokWord {
+		if f, err := strconv.ParseFloat(valTok.text, 64); err == nil {
+			n.num, n.isNum = f, true
+		}
+	}
+	if n.op == "=~" || n.op == "!~" {
+		if n.re, err = regexp.Compile(n.value); err != nil {
+			return nil, fmt.Errorf("invalid regex for %s: %w", fieldTok.text, err)
+		}
+	}
+	return n, nil
+}
+
+func (p *filterParser) parseList() ([]inValue, error) {
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, fmt.Errorf("expected ( after in at offset %d", tok.pos)
+	}
+	var list []inValue
+	for {
+		tok := p.next()
+		if tok.kind != tokWord && tok.kind != tokString {
+			return nil, fmt.Errorf("expected list value at offset %d, got %q", tok.pos, tok.text)
+		}
+		item := inValue{str: tok.text}
+		if tok.kind == tokWord {
+			if f, err := strconv.ParseFloat(tok.text, 64); err == nil {
+				item.num, item.isNum = f, true
+			} else if from, to, ok := parseRange(tok.text); ok {
+				item.from, item.to, item.isRange = from, to, true
+			}
+		}
+		list = append(list, item)
+
+		switch sep := p.next(); sep.kind {
+		case tokComma:
+			continue
+		case tokRParen:
+			return list, nil
+		default:
+			return nil, fmt.Errorf("expected , or ) at offset %d, got %q", sep.pos, sep.text)
+		}
+	}
+}
+
+// parseRange parses numeric ranges of the form 1..5
+func parseRange(s string) (float64, float64, bool) {
+	from, to, ok := strings.Cut(s, "..")
+	if !ok {
+		return 0, 0, false
+	}
+	f, err := strconv.ParseFloat(from, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	t, err := strconv.ParseFloat(to, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return f, t, true
+}
@@ -0,0 +1,181 @@
+package log
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+func toEventMap(t *testing.T, v interface{}) map[string]interface{} {
+	arr, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(arr, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestFilterExpressions(t *testing.T) {
+	alert := toEventMap(t, &pb.Alert{
+		NamespaceName: "prod",
+		PodName:       "debug-shell",
+		Owner:         &pb.Podowner{Name: "web", Ref: "Deployment"},
+		Operation:     "File",
+		Action:        "Block",
+		Severity:      "7",
+		PID:           1234,
+		ATags:         []string{"MITRE", "NIST"},
+	})
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"Operation==File", true},
+		{"operation == 'File'", true},
+		{"Operation==Process", false},
+		{"Operation==File AND (Action==Block OR Severity>=5) AND NOT PodName=~^debug", false},
+		{"Operation==File AND (Action==Audit OR Severity>=5)", true},
+		{"Severity > 10 || PID in (1..2000)", true},
+		{"PID in (1, 2, 3)", false},
+		{"NamespaceName in (prod, staging)", true},
+		{"Owner.Name == web && Owner.Ref != StatefulSet", true},
+		{"ATags == NIST", true},
+		{"PolicyName == ''", true},
+		{"!(Action == Block)", false},
+		{`PodName =~ "(?i)DEBUG-.*"`, true},
+		{"PodName !~ shell", false},
+	}
+	for _, tc := range tests {
+		f, err := CompileFilter(tc.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		if got := f.Match(alert); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"Unknown == x",
+		"PodName ==",
+		"(PodName == x",
+		"PodName == x AND",
+		"PodName =~ '['",
+		"PID in 1, 2",
+		"PodName == 'x",
+	} {
+		if _, err := CompileFilter(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestOptionsFilter(t *testing.T) {
+	log := toEventMap(t, &pb.Log{
+		NamespaceName: "kube-system",
+		Operation:     "Process",
+		Source:        "/bin/bash",
+		Labels:        "app=nginx,tier=web",
+	})
+
+	tests := []struct {
+		o    Options
+		want bool
+	}{
+		{Options{}, true},
+		{Options{Namespace: "KUBE"}, true},
+		{Options{Operation: "file"}, false},
+		{Options{Source: "/BIN"}, false},
+		// the terms of a label selector are ANDed
+		{Options{Selector: []string{"app=httpd", "tier=web"}}, false},
+		{Options{Selector: []string{"app=nginx", "tier=web"}}, true},
+		{Options{Selector: []string{"app=httpd"}}, false},
+		{Options{Selector: []string{"app in (httpd", "nginx)", "!canary"}}, true},
+		{Options{Selector: []string{"app notin (nginx)"}}, false},
+		{Options{Selector: []string{"tier!=frontend", "app"}}, true},
+		{Options{Selector: []string{"!tier"}}, false},
+		{Options{Namespace: "kube", Filter: "Source == /bin/sh"}, false},
+		{Options{Namespace: "kube", Filter: "Source == /bin/bash"}, true},
+		{Options{ContainerName: "nginx"}, false},
+	}
+	for _, tc := range tests {
+		f, err := NewOptionsFilter(tc.o)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.o, err)
+			continue
+		}
+		if got := f.Match(log); got != tc.want {
+			t.Errorf("%s: got %v, want %v", f, got, tc.want)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	if _, err := NewOptionsFilter(Options{Selector: []string{"app in (nginx"}}); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+	sel, err := ParseLabelSelector([]string{"env in (prod", "staging)", "!canary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for labels, want := range map[string]bool{
+		"env=prod,app=web":         true,
+		"env=staging":              true,
+		"env=prod,canary=true":     false,
+		"env=dev":                  false,
+		"":                         false,
+		"env = staging , tier=web": true,
+	} {
+		if got := MatchLabels(sel, labels); got != want {
+			t.Errorf("%q: got %v, want %v", labels, got, want)
+		}
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	since, err := ParseTime("10m", now)
+	if err != nil || !since.Equal(now.Add(-10*time.Minute)) {
+		t.Fatalf("unexpected --since %s (%v)", since, err)
+	}
+	until, err := ParseTime("2024-05-01T12:05:00Z", now)
+	if err != nil || !until.Equal(now.Add(5*time.Minute)) {
+		t.Fatalf("unexpected --until %s (%v)", until, err)
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+	if deadline, err := ParseDeadline("10m", now); err != nil || !deadline.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("unexpected --until deadline %s (%v)", deadline, err)
+	}
+	if deadline, err := ParseDeadline("2024-05-01T12:05:00Z", now); err != nil || !deadline.Equal(until) {
+		t.Errorf("unexpected --until deadline %s (%v)", deadline, err)
+	}
+
+	f, err := NewOptionsFilter(Options{Since: since, Until: until})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset, want := range map[time.Duration]bool{
+		-11 * time.Minute: false,
+		-10 * time.Minute: true,
+		0:                 true,
+		5 * time.Minute:   true,
+		6 * time.Minute:   false,
+	} {
+		alert := toEventMap(t, &pb.Alert{Timestamp: now.Add(offset).Unix()})
+		if got := f.Match(alert); got != want {
+			t.Errorf("%s: got %v, want %v", offset, got, want)
+		}
+	}
+}
@@ -0,0 +1,517 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Formatter renders an alert or log for output. t is the event type
+// ("Alert"/"Log"), res the decoded event and arr its JSON encoding.
+type Formatter func(t string, res map[string]interface{}, arr []byte) (string, error)
+
+var (
+	formattersLock sync.RWMutex
+	formatters     = map[string]Formatter{
+		"text":        formatText,
+		"json":        formatJSON,
+		"pretty-json": formatPrettyJSON,
+		"cef":         formatCEF,
+		"leef":        formatLEEF,
+		"ecs":         formatECS,
+	}
+)
+
+// RegisterFormatter adds or replaces an output format
+func RegisterFormatter(name string, f Formatter) {
+	formattersLock.Lock()
+	defer formattersLock.Unlock()
+	formatters[name] = f
+}
+
+// GetFormatter returns the formatter registered for the output format. An
+// empty name selects the text format.
+func GetFormatter(name string) (Formatter, error) {
+	if name == "" {
+		name = "text"
+	}
+	formattersLock.RLock()
+	defer formattersLock.RUnlock()
+	f, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q, supported formats: %s", name, strings.Join(formatterNames(), ", "))
+	}
+	return f, nil
+}
+
+// FormatterNames lists the registered output formats
+func FormatterNames() []string {
+	formattersLock.RLock()
+	defer formattersLock.RUnlock()
+	return formatterNames()
+}
+
+func formatterNames() []string {
+	names := make([]string, 0, len(formatters))
+	for n := range formatters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputFormat returns the output format selected by the options
+func (o Options) outputFormat() string {
+	if o.JSON {
+		return "json"
+	}
+	if o.Output == "" {
+		return "text"
+	}
+	return o.Output
+}
+
+// ==================== //
+// == Native Formats == //
+// ==================== //
+
+func formatJSON(_ string, _ map[string]interface{}, arr []byte) (string, error) {
+	return fmt.Sprintf("%s\n", string(arr)), nil
+}
+
+func formatPrettyJSON(_ string, _ map[string]interface{}, arr []byte) (string, error) {
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, arr, "", "  "); err != nil {
+		return "", fmt.Errorf("failed to prettify JSON (%s)", err.Error())
+	}
+	return fmt.Sprintf("%s\n", prettyJSON.String()), nil
+}
+
+func formatText(t string, res map[string]interface{}, _ []byte) (string, error) {
+	str := ""
+	if time, ok := res["UpdatedTime"].(string); ok {
+		updatedTime := strings.Replace(time, "T", " ", -1)
+		updatedTime = strings.Replace(updatedTime, "Z", "", -1)
+		str = fmt.Sprintf("== %s / %s ==\n", t, updatedTime)
+	} else {
+		str = fmt.Sprintf("== %s ==\n", t)
+	}
+
+	// Array of Keys to preserve order in Output
+	telKeys := []string{
+		"UpdatedTime",
+		"Timestamp",
+		"ClusterName",
+		"ClusterContext",
+		"NodeName",
+		"HostName",
+		"NamespaceName",
+		"PodName",
+		"Workload",
+		"Labels",
+		"ContainerName",
+		"ContainerID",
+		"ContainerImage",
+		"Type",
+		"PolicyName",
+		"PolicyAction",
+		"PolicyMessage",
+		"PolicyTags",
+		"Severity",
+		"Message",
+		"Source",
+		"ProcessTree",
+		"Resource",
+		"Operation",
+		"Action",
+		"Data",
+		"EventData",
+		"Enforcer",
+		"Result",
+	}
+
+	var additionalKeys []string
+	// Looping through the Map to find additional keys not present in our array
+	for k := range res {
+		if !slices.Contains(telKeys, k) {
+			additionalKeys = append(additionalKeys, k)
+		}
+	}
+	sort.Strings(additionalKeys)
+	telKeys = append(telKeys, additionalKeys...)
+
+	for i := 2; i < len(telKeys); i++ { // Starting the loop from index 2 to skip printing timestamp again
+		k := telKeys[i]
+		// Check if fields are present in the structure and if present verifying that they are not empty
+		// Certain fields like Container* are not present in HostLogs, this check handles that and other edge cases
+		if v, ok := res[k]; ok && v != "" {
+			if _, ok := res[k].(float64); ok {
+				str = str + fmt.Sprintf("%s: %.0f\n", k, res[k])
+			} else {
+				str = str + fmt.Sprintf("%s: %v\n", k, res[k])
+			}
+		}
+	}
+	return str, nil
+}
+
+// ================== //
+// == SIEM Formats == //
+// ================== //
+
+const (
+	siemVendor  = "KubeArmor"
+	siemProduct = "KubeArmor"
+)
+
+// siemFields holds the event fields shared by the SIEM formats
+type siemFields struct {
+	version   string
+	eventID   string
+	name      string
+	severity  int
+	timestamp time.Time
+}
+
+func newSIEMFields(t string, res map[string]interface{}) siemFields {
+	sf := siemFields{
+		version:   fieldString(res, "KubeArmorVersion"),
+		eventID:   fieldString(res, "PolicyName"),
+		name:      fieldString(res, "Message"),
+		timestamp: eventTime(res),
+	}
+	if sf.version == "" {
+		sf.version = "unknown"
+	}
+	op := fieldString(res, "Operation")
+	if sf.eventID == "" {
+		sf.eventID = t + ":" + op
+	}
+	if sf.name == "" {
+		sf.name = strings.TrimSpace(t + " " + op + " " + fieldString(res, "Action"))
+	}
+
+	// KubeArmor severities are 1-10, logs have none
+	sf.severity = 1
+	if t == "Alert" {
+		sf.severity = 5
+	}
+	if s, err := strconv.Atoi(fieldString(res, "Severity")); err == nil && s >= 0 && s <= 10 {
+		sf.severity = s
+	}
+	return sf
+}
+
+// cefEscaper escapes CEF header fields
+var cefEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+
+// cefExtEscaper escapes CEF extension values
+var cefExtEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+// formatCEF renders an ArcSight Common Event Format record
+func formatCEF(t string, res map[string]interface{}, _ []byte) (string, error) {
+	sf := newSIEMFields(t, res)
+
+	ext := []string{
+		"rt", strconv.FormatInt(sf.timestamp.UnixMilli(), 10),
+		"dvchost", fieldString(res, "HostName"),
+		"deviceExternalId", fieldString(res, "ContainerID"),
+		"cat", fieldString(res, "Operation"),
+		"act", fieldString(res, "Action"),
+		"outcome", fieldString(res, "Result"),
+		"msg", fieldString(res, "Data"),
+		"sproc", fieldString(res, "Source"),
+		"spid", fieldString(res, "PID"),
+		"suid", fieldString(res, "UID"),
+		"suser", fieldString(res, "UserName"),
+		"cs1Label", "policyName", "cs1", fieldString(res, "PolicyName"),
+		"cs2Label", "namespace", "cs2", fieldString(res, "NamespaceName"),
+		"cs3Label", "pod", "cs3", fieldString(res, "PodName"),
+		"cs4Label", "containerImage", "cs4", fieldString(res, "ContainerImage"),
+		"cs5Label", "tags", "cs5", eventTags(res),
+		"cs6Label", "cluster", "cs6", fieldString(res, "ClusterName"),
+		"cn1Label", "ppid", "cn1", fieldString(res, "PPID"),
+		"cn2Label", "hostPid", "cn2", fieldString(res, "HostPID"),
+		"cn3Label", "hostPpid", "cn3", fieldString(res, "HostPPID"),
+	}
+	switch fieldString(res, "Operation") {
+	case "File":
+		ext = append(ext, "filePath", fieldString(res, "Resource"))
+	case "Process":
+		ext = append(ext, "dproc", fieldString(res, "Resource"))
+	default:
+		ext = append(ext, "request", fieldString(res, "Resource"))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		siemVendor, siemProduct, cefEscaper.Replace(sf.version),
+		cefEscaper.Replace(sf.eventID), cefEscaper.Replace(sf.name), sf.severity)
+	writeExtensions(&sb, ext, " ", cefExtEscaper)
+	sb.WriteString("\n")
+	return sb.String(), nil
+}
+
+// leefEscaper strips the attribute delimiter and line breaks from LEEF values
+var leefEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// leefHeaderEscaper strips the header delimiter, which LEEF cannot escape
+var leefHeaderEscaper = strings.NewReplacer("|", " ", "\n", " ", "\r", " ")
+
+// formatLEEF renders an IBM QRadar Log Event Extended Format 2.0 record
+func formatLEEF(t string, res map[string]interface{}, _ []byte) (string, error) {
+	sf := newSIEMFields(t, res)
+
+	attrs := []string{
+		"devTime", strconv.FormatInt(sf.timestamp.UnixMilli(), 10),
+		"devTimeFormat", "epoch",
+		"cat", fieldString(res, "Operation"),
+		"sev", strconv.Itoa(sf.severity),
+		"identHostName", fieldString(res, "HostName"),
+		"usrName", fieldString(res, "UserName"),
+		"policyName", fieldString(res, "PolicyName"),
+		"action", fieldString(res, "Action"),
+		"result", fieldString(res, "Result"),
+		"resource", fieldString(res, "Resource"),
+		"source", fieldString(res, "Source"),
+		"pid", fieldString(res, "PID"),
+		"ppid", fieldString(res, "PPID"),
+		"hostPid", fieldString(res, "HostPID"),
+		"hostPpid", fieldString(res, "HostPPID"),
+		"uid", fieldString(res, "UID"),
+		"cluster", fieldString(res, "ClusterName"),
+		"namespace", fieldString(res, "NamespaceName"),
+		"pod", fieldString(res, "PodName"),
+		"container", fieldString(res, "ContainerName"),
+		"containerImage", fieldString(res, "ContainerImage"),
+		"tags", eventTags(res),
+		"msg", sf.name,
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "LEEF:2.0|%s|%s|%s|%s|",
+		siemVendor, siemProduct, leefHeaderEscaper.Replace(sf.version), leefHeaderEscaper.Replace(sf.eventID))
+	writeExtensions(&sb, attrs, "\t", leefEscaper)
+	sb.WriteString("\n")
+	return sb.String(), nil
+}
+
+// writeExtensions writes the non-empty key=value pairs
+func writeExtensions(sb *strings.Builder, kv []string, sep string, esc *strings.Replacer) {
+	first := true
+	for i := 0; i+1 < len(kv); i += 2 {
+		// skip empty values along with the label preceding them
+		if kv[i+1] == "" || (strings.HasSuffix(kv[i], "Label") && i+3 < len(kv) && kv[i+3] == "") {
+			continue
+		}
+		if !first {
+			sb.WriteString(sep)
+		}
+		first = false
+		sb.WriteString(kv[i])
+		sb.WriteString("=")
+		sb.WriteString(esc.Replace(kv[i+1]))
+	}
+}
+
+// ecsVersion is the Elastic Common Schema version the ECS output follows
+const ecsVersion = "8.11.0"
+
+// formatECS renders an Elastic Common Schema JSON document
+func formatECS(t string, res map[string]interface{}, _ []byte) (string, error) {
+	sf := newSIEMFields(t, res)
+	op := fieldString(res, "Operation")
+
+	event := map[string]interface{}{
+		"module":   "kubearmor",
+		"dataset":  "kubearmor." + strings.ToLower(t),
+		"kind":     "event",
+		"severity": sf.severity,
+		"action":   fieldString(res, "Action"),
+		"outcome":  "unknown",
+	}
+	if t == "Alert" {
+		event["kind"] = "alert"
+	}
+	switch op {
+	case "Process":
+		event["category"] = []string{"process"}
+	case "File":
+		event["category"] = []string{"file"}
+	case "Network":
+		event["category"] = []string{"network"}
+	}
+	switch strings.ToLower(fieldString(res, "Action")) {
+	case "block":
+		event["type"] = []string{"denied"}
+	case "allow":
+		event["type"] = []string{"allowed"}
+	default:
+		event["type"] = []string{"info"}
+	}
+	switch result := fieldString(res, "Result"); {
+	case result == "Passed":
+		event["outcome"] = "success"
+	case result != "":
+		event["outcome"] = "failure"
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": sf.timestamp.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]interface{}{"version": ecsVersion},
+		"message":    sf.name,
+		"event":      event,
+		"host":       map[string]interface{}{"name": fieldString(res, "HostName"), "hostname": fieldString(res, "HostName")},
+		"observer":   map[string]interface{}{"vendor": siemVendor, "product": siemProduct, "version": sf.version},
+	}
+
+	if policy := fieldString(res, "PolicyName"); policy != "" {
+		doc["rule"] = map[string]interface{}{"name": policy, "description": fieldString(res, "Message")}
+	}
+
+	orchestrator := map[string]interface{}{"type": "kubernetes"}
+	if cluster := fieldString(res, "ClusterName"); cluster != "" {
+		orchestrator["cluster"] = map[string]interface{}{"name": cluster}
+	}
+	if ns := fieldString(res, "NamespaceName"); ns != "" {
+		orchestrator["namespace"] = ns
+	}
+	if pod := fieldString(res, "PodName"); pod != "" {
+		orchestrator["resource"] = map[string]interface{}{"type": "pod", "name": pod}
+	}
+	doc["orchestrator"] = orchestrator
+
+	if id := fieldString(res, "ContainerID"); id != "" {
+		container := map[string]interface{}{"id": id, "name": fieldString(res, "ContainerName")}
+		if image := fieldString(res, "ContainerImage"); image != "" {
+			container["image"] = map[string]interface{}{"name": image}
+		}
+		doc["container"] = container
+	}
+
+	process := map[string]interface{}{
+		"executable": fieldString(res, "Source"),
+		"name":       fieldString(res, "ProcessName"),
+		"parent": map[string]interface{}{
+			"pid":        fieldNumber(res, "PPID"),
+			"executable": fieldString(res, "ParentProcessName"),
+		},
+	}
+	if pid := fieldNumber(res, "PID"); pid != 0 {
+		process["pid"] = pid
+	}
+	if cwd := fieldString(res, "Cwd"); cwd != "" {
+		process["working_directory"] = cwd
+	}
+	if op == "Process" {
+		process["command_line"] = fieldString(res, "Resource")
+	}
+	doc["process"] = process
+
+	if op == "File" {
+		doc["file"] = map[string]interface{}{"path": fieldString(res, "Resource")}
+	}
+
+	user := map[string]interface{}{"id": fieldString(res, "UID")}
+	if name := fieldString(res, "UserName"); name != "" {
+		user["name"] = name
+	}
+	doc["user"] = user
+
+	if tags := eventTags(res); tags != "" {
+		doc["tags"] = strings.Split(tags, ",")
+	}
+	if labels := eventLabels(res); len(labels) != 0 {
+		doc["labels"] = labels
+	}
+
+	doc["kubearmor"] = map[string]interface{}{
+		"type":       fieldString(res, "Type"),
+		"operation":  op,
+		"resource":   fieldString(res, "Resource"),
+		"data":       fieldString(res, "Data"),
+		"enforcer":   fieldString(res, "Enforcer"),
+		"result":     fieldString(res, "Result"),
+		"host_pid":   fieldNumber(res, "HostPID"),
+		"host_ppid":  fieldNumber(res, "HostPPID"),
+		"event_type": t,
+	}
+
+	arr, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(arr) + "\n", nil
+}
+
+// ============= //
+// == Helpers == //
+// ============= //
+
+// fieldString returns the field as a string, "" if missing
+func fieldString(res map[string]interface{}, key string) string {
+	v, ok := res[key]
+	if !ok {
+		return ""
+	}
+	return valueString(v)
+}
+
+// fieldNumber returns a numeric field, 0 if missing
+func fieldNumber(res map[string]interface{}, key string) float64 {
+	f, _ := valueNumber(res[key])
+	return f
+}
+
+// eventTime returns the event time from Timestamp or UpdatedTime
+func eventTime(res map[string]interface{}) time.Time {
+	if ts, ok := res["UpdatedTime"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t
+		}
+	}
+	if ts := fieldNumber(res, "Timestamp"); ts > 0 {
+		return time.Unix(int64(ts), 0)
+	}
+	return time.Now()
+}
+
+// eventTags merges Tags and ATags into one comma separated list
+func eventTags(res map[string]interface{}) string {
+	var tags []string
+	for _, t := range strings.Split(fieldString(res, "Tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	if atags, ok := res["ATags"].([]interface{}); ok {
+		for _, a := range atags {
+			if t := strings.TrimSpace(valueString(a)); t != "" && !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// eventLabels parses the comma separated key=value Labels field
+func eventLabels(res map[string]interface{}) map[string]string {
+	labels := map[string]string{}
+	for _, l := range strings.Split(fieldString(res, "Labels"), ",") {
+		k, v, ok := strings.Cut(l, "=")
+		if ok && k != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+var formatAlert = &pb.Alert{
+	Timestamp:        1700000000,
+	UpdatedTime:      "2023-11-14T22:13:20.123456Z",
+	ClusterName:      "default",
+	HostName:         "node-1",
+	NamespaceName:    "prod",
+	PodName:          "web-7f9",
+	Labels:           "app=web,tier=frontend",
+	ContainerID:      "abc123",
+	ContainerName:    "web",
+	ContainerImage:   "nginx:1.25",
+	PID:              42,
+	PPID:             1,
+	HostPID:          4242,
+	PolicyName:       "block-shadow",
+	Severity:         "7",
+	Tags:             "MITRE,PCI_DSS",
+	ATags:            []string{"MITRE", "NIST_800-53_AU-2"},
+	Message:          "shadow|access = denied",
+	Source:           "/bin/cat",
+	Operation:        "File",
+	Resource:         "/etc/shadow",
+	Action:           "Block",
+	Result:           "Permission denied",
+	KubeArmorVersion: "v1.4.0",
+}
+
+func renderAlert(t *testing.T, format string) string {
+	arr, err := json.Marshal(formatAlert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(arr, &res); err != nil {
+		t.Fatal(err)
+	}
+	f, err := GetFormatter(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := f("Alert", res, arr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return str
+}
+
+func TestFormatCEF(t *testing.T) {
+	str := renderAlert(t, "cef")
+	wantPrefix := `CEF:0|KubeArmor|KubeArmor|v1.4.0|block-shadow|shadow\|access = denied|7|`
+	if !strings.HasPrefix(str, wantPrefix) {
+		t.Fatalf("unexpected header in %q", str)
+	}
+	for _, want := range []string{
+		"rt=1700000000123", "act=Block", "filePath=/etc/shadow", "spid=42",
+		"cs1Label=policyName cs1=block-shadow", "cs3Label=pod cs3=web-7f9",
+		"cs5Label=tags cs5=MITRE,PCI_DSS,NIST_800-53_AU-2",
+	} {
+		if !strings.Contains(str, want) {
+			t.Errorf("missing %q in %q", want, str)
+		}
+	}
+	if strings.Contains(str, "suser=") {
+		t.Errorf("empty fields should be omitted: %q", str)
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	str := renderAlert(t, "leef")
+	if !strings.HasPrefix(str, "LEEF:2.0|KubeArmor|KubeArmor|v1.4.0|block-shadow|") {
+		t.Fatalf("unexpected header in %q", str)
+	}
+	attrs := strings.Split(strings.TrimSpace(strings.SplitN(str, "|", 6)[5]), "\t")
+	for _, want := range []string{"sev=7", "cat=File", "namespace=prod", "msg=shadow|access = denied"} {
+		found := false
+		for _, a := range attrs {
+			found = found || a == want
+		}
+		if !found {
+			t.Errorf("missing %q in %v", want, attrs)
+		}
+	}
+}
+
+func TestFormatECS(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(renderAlert(t, "ecs")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	event := doc["event"].(map[string]interface{})
+	if event["kind"] != "alert" || event["outcome"] != "failure" || event["severity"] != float64(7) {
+		t.Errorf("unexpected event %v", event)
+	}
+	if doc["@timestamp"] != "2023-11-14T22:13:20.123456Z" {
+		t.Errorf("unexpected @timestamp %v", doc["@timestamp"])
+	}
+	if doc["rule"].(map[string]interface{})["name"] != "block-shadow" {
+		t.Errorf("unexpected rule %v", doc["rule"])
+	}
+	if doc["file"].(map[string]interface{})["path"] != "/etc/shadow" {
+		t.Errorf("unexpected file %v", doc["file"])
+	}
+	orch := doc["orchestrator"].(map[string]interface{})
+	if orch["namespace"] != "prod" || orch["resource"].(map[string]interface{})["name"] != "web-7f9" {
+		t.Errorf("unexpected orchestrator %v", orch)
+	}
+	if doc["labels"].(map[string]interface{})["tier"] != "frontend" {
+		t.Errorf("unexpected labels %v", doc["labels"])
+	}
+}
+
+func TestUnknownFormatter(t *testing.T) {
+	if _, err := GetFormatter("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	RegisterFormatter("policy-only", func(_ string, res map[string]interface{}, _ []byte) (string, error) {
+		return fieldString(res, "PolicyName") + "\n", nil
+	})
+	if str := renderAlert(t, "policy-only"); str != "block-shadow\n" {
+		t.Errorf("unexpected output %q", str)
+	}
+}
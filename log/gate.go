@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// gate decides the outcome of a CI run from the events observed: it passes
+// once an event matches Expect, and fails as soon as one matches FailOn
+type gate struct {
+	expect *Filter
+	failOn *Filter
+	junit  string
+	warn   io.Writer
+
+	start time.Time
+	done  chan struct{}
+	once  sync.Once
+
+	mu       sync.Mutex
+	expected []byte    // first event matching expect
+	failed   []byte    // first event matching failOn
+	matched  time.Time // when expected or failed was seen
+}
+
+// newGate compiles the --expect and --fail-on filters of the options, it
+// returns nil if none is set
+func newGate(o Options) (*gate, error) {
+	if o.Expect == "" && o.FailOn == "" {
+		return nil, nil
+	}
+	g := &gate{junit: o.JUnit, warn: o.stderr(), start: time.Now(), done: make(chan struct{})}
+	var err error
+	if o.Expect != "" {
+		if g.expect, err = CompileFilter(o.Expect); err != nil {
+			return nil, fmt.Errorf("invalid --expect: %w", err)
+		}
+	}
+	if o.FailOn != "" {
+		if g.failOn, err = CompileFilter(o.FailOn); err != nil {
+			return nil, fmt.Errorf("invalid --fail-on: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// observe checks an event against the criteria
+func (g *gate) observe(res map[string]interface{}, arr []byte) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failOn != nil && g.failed == nil && g.failOn.Match(res) {
+		g.failed = arr
+		g.matched = time.Now()
+		g.decide()
+		return
+	}
+	if g.expect != nil && g.expected == nil && g.expect.Match(res) {
+		g.expected = arr
+		if g.failed == nil {
+			g.matched = time.Now()
+		}
+		// --fail-on keeps watching for the whole --duration
+		if g.failOn == nil {
+			g.decide()
+		}
+	}
+}
+
+func (g *gate) decide() {
+	g.once.Do(func() {
+		close(g.done)
+	})
+}
+
+// watch returns ctx, cancelled once the outcome is decided
+func (g *gate) watch(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if g != nil {
+		go func() {
+			select {
+			case <-g.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// result returns the error of a failed run, err if the observation itself
+// failed, and writes the JUnit report
+func (g *gate) result(err error) error {
+	if g == nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	elapsed := time.Since(g.start)
+	suite := junitSuite{Name: "karmor logs", Timestamp: g.start.UTC().Format(time.RFC3339), Time: seconds(elapsed)}
+
+	var failure error
+	if g.expect != nil {
+		tc := junitCase{ClassName: "karmor.logs", Name: "expect: " + g.expect.String(), Time: seconds(elapsed)}
+		if g.expected == nil {
+			msg := fmt.Sprintf("no event matched %q within %s", g.expect.String(), elapsed.Round(time.Second))
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			tc.Failure = &junitFailure{Message: msg}
+			failure = fmt.Errorf("--expect failed, %s", msg)
+		} else {
+			tc.Time = seconds(g.matched.Sub(g.start))
+			tc.SystemOut = string(g.expected)
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if g.failOn != nil {
+		tc := junitCase{ClassName: "karmor.logs", Name: "fail-on: " + g.failOn.String(), Time: seconds(elapsed)}
+		if g.failed != nil {
+			msg := fmt.Sprintf("an event matched %q", g.failOn.String())
+			tc.Time = seconds(g.matched.Sub(g.start))
+			tc.Failure = &junitFailure{Message: msg, Text: string(g.failed)}
+			failure = fmt.Errorf("--fail-on failed, %s: %s", msg, g.failed)
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for _, tc := range suite.Cases {
+		suite.Tests++
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	if g.junit != "" {
+		if werr := writeJUnit(g.junit, suite); werr != nil {
+			fmt.Fprintf(g.warn, "Failed to write the JUnit report (%s)\n", werr.Error())
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+	if g.expected != nil {
+		// the observers were stopped on purpose
+		return nil
+	}
+	return err
+}
+
+// =========== //
+// == JUnit == //
+// =========== //
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func writeJUnit(path string, suite junitSuite) error {
+	arr, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	arr = append([]byte(xml.Header), arr...)
+	return os.WriteFile(filepath.Clean(path), append(arr, '\n'), 0o600)
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// gateCapture writes alerts to a capture file to replay
+func gateCapture(t *testing.T, alerts ...*pb.Alert) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gate.kalog")
+	start := time.Now()
+	cw, err := NewCaptureWriter(path, CaptureHeader{Timestamp: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range alerts {
+		if err := cw.Write(CaptureRecord{Time: start.Add(time.Duration(i) * time.Millisecond), Alert: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGate(t *testing.T) {
+	capture := gateCapture(t,
+		&pb.Alert{PolicyName: "audit-curl", Action: "Audit"},
+		&pb.Alert{PolicyName: "block-shadow", Action: "Block"},
+		&pb.Alert{PolicyName: "audit-wget", Action: "Audit"},
+	)
+
+	for _, tc := range []struct {
+		name     string
+		o        Options
+		wantErr  string
+		failures int
+	}{
+		{"expect met", Options{Expect: "Action==Block"}, "", 0},
+		{"expect unmet", Options{Expect: "PolicyName==nope", Timeout: time.Minute}, "--expect failed", 1},
+		{"fail-on hit", Options{FailOn: "Action==Block"}, "--fail-on failed", 1},
+		{"fail-on clean", Options{FailOn: "PolicyName=~^deny"}, "", 0},
+		{"both", Options{Expect: "PolicyName==audit-wget", FailOn: "Action==Block"}, "--fail-on failed", 1},
+		{"filtered out", Options{Expect: "Action==Block", Filter: "Action!=Block"}, "--expect failed", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := tc.o
+			o.Replay = capture
+			o.LogFilter = "policy"
+			o.LogPath = "none"
+			o.MsgPath = "none"
+			o.JUnit = filepath.Join(t.TempDir(), "result.xml")
+
+			err := StartReplay(o)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("expected %q, got %v", tc.wantErr, err)
+			}
+
+			data, err := os.ReadFile(o.JUnit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report junitSuites
+			if err := xml.Unmarshal(data, &report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Suites) != 1 || report.Suites[0].Failures != tc.failures {
+				t.Errorf("unexpected report %s", data)
+			}
+		})
+	}
+
+	if err := StartReplay(Options{Replay: capture, LogFilter: "policy", LogPath: "none", MsgPath: "none",
+		Expect: "Action=="}); err == nil {
+		t.Error("expected an error for an invalid --expect")
+	}
+}
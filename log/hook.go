@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/shlex"
+	"golang.org/x/time/rate"
+)
+
+// hookEnv are the environment variables set from the fields of an alert
+var hookEnv = []struct{ name, field string }{
+	{"KARMOR_POLICY", "PolicyName"},
+	{"KARMOR_POD", "PodName"},
+	{"KARMOR_NAMESPACE", "NamespaceName"},
+	{"KARMOR_ACTION", "Action"},
+	{"KARMOR_CONTAINER_ID", "ContainerID"},
+	{"KARMOR_HOST", "HostName"},
+}
+
+// alertHook runs a local command for every alert passing the filters, with
+// the alert JSON on stdin. A bounded pool of workers runs the commands with a
+// timeout each, and alerts over the rate limit or a full queue are skipped.
+type alertHook struct {
+	argv    []string
+	timeout time.Duration
+	limiter *rate.Limiter
+	warn    io.Writer // also gets the output of the commands
+
+	queue   chan hookEvent
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+}
+
+type hookEvent struct {
+	data []byte
+	env  []string
+}
+
+// newAlertHook starts the workers of the --exec-on-alert command
+func newAlertHook(o Options) (*alertHook, error) {
+	argv, err := shlex.Split(o.ExecOnAlert)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --exec-on-alert: %w", err)
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("--exec-on-alert has no command")
+	}
+	workers := o.ExecWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	limit := rate.Inf
+	if o.ExecRate > 0 {
+		limit = rate.Limit(o.ExecRate)
+	}
+
+	h := &alertHook{
+		argv:    argv,
+		timeout: o.ExecTimeout,
+		limiter: rate.NewLimiter(limit, workers),
+		warn:    o.stderr(),
+		queue:   make(chan hookEvent, workers),
+	}
+	for i := 0; i < workers; i++ {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			for ev := range h.queue {
+				h.run(ev)
+			}
+		}()
+	}
+	return h, nil
+}
+
+// submit queues the command for an alert, unless rate limited or all the
+// workers are busy
+func (h *alertHook) submit(res map[string]interface{}, arr []byte) {
+	if h == nil {
+		return
+	}
+	ev := hookEvent{data: arr}
+	for _, e := range hookEnv {
+		ev.env = append(ev.env, e.name+"="+fieldString(res, e.field))
+	}
+
+	if !h.limiter.Allow() {
+		h.drop()
+		return
+	}
+	select {
+	case h.queue <- ev:
+	default:
+		h.drop()
+	}
+}
+
+func (h *alertHook) drop() {
+	if h.dropped.Add(1) == 1 {
+		fmt.Fprintln(h.warn, "Skipping --exec-on-alert for alerts over the rate limit or while all workers are busy")
+	}
+}
+
+func (h *alertHook) run(ev hookEvent) {
+	ctx := context.Background()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	// #nosec G204 -- the command is given by the user running karmor
+	cmd := exec.CommandContext(ctx, h.argv[0], h.argv[1:]...)
+	cmd.Stdin = bytes.NewReader(ev.data)
+	cmd.Env = append(os.Environ(), ev.env...)
+	// keep stdout for the alerts themselves
+	cmd.Stdout = h.warn
+	cmd.Stderr = h.warn
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", h.timeout)
+		}
+		fmt.Fprintf(h.warn, "Failed to run --exec-on-alert (%s)\n", err.Error())
+	}
+}
+
+// close waits for the running commands
+func (h *alertHook) close() {
+	if h == nil {
+		return
+	}
+	close(h.queue)
+	h.wg.Wait()
+	if n := h.dropped.Load(); n > 0 {
+		fmt.Fprintf(h.warn, "Skipped --exec-on-alert for %d alerts\n", n)
+	}
+}
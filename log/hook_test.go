@@ -0,0 +1,93 @@
+//go:build linux || darwin
+
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// hookAlerts feeds alerts through the output pipeline with --exec-on-alert
+// and waits for the commands
+func hookAlerts(t *testing.T, o Options, alerts ...*pb.Alert) {
+	t.Helper()
+	o.LogPath = "none"
+	o.LogFilter = "policy"
+	o.Sinks = []string{}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range alerts {
+		arr, _ := json.Marshal(a)
+		handleTelemetry(arr, "Alert", o)
+	}
+	o.release()
+}
+
+func TestExecOnAlert(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OUT", dir)
+
+	hookAlerts(t, Options{
+		ExecOnAlert: `sh -c 'cat > "$OUT/$KARMOR_POLICY.json"; echo "$KARMOR_POD $KARMOR_NAMESPACE $KARMOR_ACTION" > "$OUT/$KARMOR_POLICY.env"'`,
+		ExecWorkers: 2,
+		Filter:      "Action==Block",
+	},
+		&pb.Alert{PolicyName: "block-shadow", PodName: "web", NamespaceName: "prod", Action: "Block"},
+		&pb.Alert{PolicyName: "audit-curl", PodName: "api", NamespaceName: "prod", Action: "Audit"},
+	)
+
+	env, err := os.ReadFile(filepath.Join(dir, "block-shadow.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(env) != "web prod Block\n" {
+		t.Errorf("unexpected environment %q", env)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "block-shadow.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got pb.Alert
+	if err := json.Unmarshal(data, &got); err != nil || got.PolicyName != "block-shadow" {
+		t.Errorf("unexpected stdin %s (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "audit-curl.env")); err == nil {
+		t.Error("expected the filtered out alert not to run the command")
+	}
+}
+
+func TestExecOnAlertLimits(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OUT", dir)
+
+	var storm []*pb.Alert
+	for i := 0; i < 20; i++ {
+		storm = append(storm, &pb.Alert{PolicyName: "storm"})
+	}
+	hookAlerts(t, Options{ExecOnAlert: `sh -c 'echo x >> "$OUT/count"'`, ExecWorkers: 1, ExecRate: 0.001}, storm...)
+	data, err := os.ReadFile(filepath.Join(dir, "count"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("expected the rate limit to allow 1 command, got %d", n)
+	}
+
+	start := time.Now()
+	hookAlerts(t, Options{ExecOnAlert: "sleep 10", ExecWorkers: 1, ExecTimeout: 100 * time.Millisecond},
+		&pb.Alert{PolicyName: "slow"})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed after its timeout, took %s", elapsed)
+	}
+
+	if err := (&Options{ExecOnAlert: `sh -c 'unterminated`}).prepare(); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
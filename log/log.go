@@ -5,47 +5,45 @@
 package log
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
-	"regexp"
-	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
+	pb "github.com/kubearmor/KubeArmor/protobuf"
 	"github.com/kubearmor/kubearmor-client/k8s"
 	"github.com/kubearmor/kubearmor-client/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-type regexType *regexp.Regexp
-
 const (
 	SelfCertProvider   string = "self"
 	ExternalCertLoader string = "external"
 )
 
-// Regex Compiled Structs
-var (
-	CNamespace     regexType
-	CLogtype       regexType
-	COperation     regexType
-	CContainerName regexType
-	CPodName       regexType
-	CSource        regexType
-	CResource      regexType
-)
-
 // Options Structure
 type Options struct {
 	GRPC             string
+	LogSource        string   // where the telemetry is read from, SourceRelay if empty, or SourcePods
+	Direct           bool     // connect to every KubeArmor daemon instead of the relay, see observeDirect
+	Nodes            []string // nodes watched with Direct, by name, glob pattern or label selector
+	Files            []string // KubeArmor log files to read instead of connecting, see observeFiles
+	Follow           bool     // keep following Files for new lines
 	Secure           bool
 	TlsCertPath      string
 	TlsCertProvider  string
 	ReadCAFromSecret bool
+	TLS              utils.TLSOptions // certificates from files, used instead of the cluster's when set
 	MsgPath          string
 	LogPath          string
+	Sinks            []string // additional output sinks, see SinkUsage
 	LogFilter        string
 	JSON             bool
 	Output           string
@@ -56,187 +54,474 @@ type Options struct {
 	PodName          string
 	Source           string
 	Resource         string
+	Filter           string // boolean filter expression, see CompileFilter
 	Limit            uint32
+	Since            time.Time     // drop events with an earlier Timestamp
+	Until            time.Time     // drop events with a later Timestamp, and stop observing then
+	Duration         time.Duration // stop observing after this long
+	Expect           string        // filter of an event to wait for, failing without one
+	FailOn           string        // filter of events failing the run
+	Timeout          time.Duration // give up waiting for Expect after this long
+	JUnit            string        // file to write the outcome of Expect and FailOn to
+	ExecOnAlert      string        // command to run for every alert, with its JSON on stdin
+	ExecWorkers      int           // commands run at once with ExecOnAlert
+	ExecTimeout      time.Duration // time limit of every ExecOnAlert command
+	ExecRate         float64       // commands started per second at most, unlimited if 0
+	Aggregate        time.Duration // roll events up per group over this window instead of printing them
+	GroupBy          []string      // fields to aggregate by, DefaultGroupBy if empty
+	Top              int           // groups in the summary printed after aggregating
+	Report           string        // tag report written once done, see ReportKinds
+	ReportFormat     string        // format of the report, see ReportFormats
+	ReportPath       string        // file to write the report to, stdout if empty
+	ProcessTree      bool          // add the exec chain of the process to alerts
+	Enrich           bool          // add the matched policy and the pod owner to alerts
+	Redact           bool          // replace the secrets found by the built-in detectors in the output
+	RedactRules      string        // file of additional redaction rules, see RedactRule
+	RedactMode       string        // how secrets are replaced, see RedactModes
+	MaxRetries       int           // reconnect attempts before giving up, 0 disables reconnecting, negative retries forever
+	RetryBackoff     time.Duration // initial delay between reconnect attempts
 	Selector         []string
+	Contexts         []string       // kubeconfig contexts to observe at once
+	AllContexts      bool           // observe every context of the kubeconfig
 	EventChan        chan EventInfo // channel to send events on
+	EventBuffer      int            // events buffered for EventChan and the Observer channels, see Overflow
+	Overflow         string         // policy once the buffer is full, see OverflowPolicies, unbuffered if empty
+	Record           string         // capture file to record the raw telemetry to
+	Replay           string         // capture file to replay instead of connecting
+	ReplaySpeed      float64        // replay speed factor, 0 replays without delays
+	MetricsListen    string         // address to serve Prometheus metrics on
+	Store            string         // event store to persist the filtered events to
+	StoreRetention   time.Duration  // prune stored events older than this, keep them if 0
+	StoreMaxEvents   uint64         // prune the oldest stored events beyond this many, keep them if 0
+	Stderr           io.Writer      // where the messages of the observer go, os.Stderr if nil
+
+	filter   *Filter        // compiled from Filter and the per-field options
+	sinks    []Sink         // opened from LogPath and Sinks
+	recorder *CaptureWriter // opened from Record
+	metrics  *Metrics       // served on MetricsListen
+	agg      *aggregator    // rolls events up with Aggregate
+	tree     *ProcessTree   // fed by all events with ProcessTree
+	enricher *Enricher      // per cluster with Enrich
+	redactor *redactor      // from Redact and RedactRules
+	gate     *gate          // decides the outcome with Expect and FailOn
+	hook     *alertHook     // runs ExecOnAlert
+	store    *EventStore    // opened from Store
+	report   *TagReport     // summarises the alerts with Report
+
+	events     *Delivery[EventInfo] // in front of EventChan with Overflow
+	alertQueue *Delivery[*pb.Alert] // in front of alertChan with Overflow
+	logQueue   *Delivery[*pb.Log]   // in front of logChan with Overflow
+
+	counters       *streamCounters  // events received per stream, for Limit
+	stop           <-chan struct{}  // closed to stop observing
+	alertChan      chan<- *pb.Alert // filtered alerts for an Observer
+	logChan        chan<- *pb.Log   // filtered logs for an Observer
+	clusterContext string           // kubeconfig context the events come from
+	daemon         *corev1.Pod      // KubeArmor pod connected to with Direct
 }
 
-// StopChan Channel
-var (
-	StopChan chan struct{}
-	sigChan  chan os.Signal
-)
+// stderr returns where the messages of the observer go
+func (o Options) stderr() io.Writer {
+	if o.Stderr != nil {
+		return o.Stderr
+	}
+	return os.Stderr
+}
+
+// watchTelemetry reports whether alerts and logs are to be watched at all
+func (o Options) watchTelemetry() bool {
+	return o.LogPath != "none" || len(o.Sinks) != 0 || o.MetricsListen != "" ||
+		o.Expect != "" || o.FailOn != "" || o.ExecOnAlert != "" || o.Store != "" || o.Report != "" || o.alertChan != nil || o.logChan != nil
+}
 
-// UnblockSignal is a flag to check whether the Watch* APIs have exited or signal has rcvd
 var (
-	UnblockSignal error
-	matchLabels         = map[string]string{"kubearmor-app": "kubearmor-relay"}
-	port          int64 = 32767
+	matchLabels       = map[string]string{"kubearmor-app": "kubearmor-relay"}
+	port        int64 = 32767
 )
 
+// osSignals stop the observers of the CLI
+var osSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGQUIT,
+	os.Interrupt,
+}
+
 // GetOSSigChannel Function
 func GetOSSigChannel() chan os.Signal {
 	c := make(chan os.Signal, 1)
 
-	signal.Notify(c,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGQUIT,
-		os.Interrupt)
+	signal.Notify(c, osSignals...)
 
 	return c
 }
 
-func regexCompile(o Options) error {
-	var err error
+// StartObserver observes the cluster until interrupted by a signal or the
+// limit is reached. Programs embedding the observer should use NewObserver.
+func StartObserver(c *k8s.Client, o Options) error {
+	return observeUntilSignal([]clusterTarget{{client: c}}, o)
+}
+
+// observeUntilSignal runs the observers of the CLI
+func observeUntilSignal(targets []clusterTarget, o Options) error {
+	if !o.valid() {
+		flag.PrintDefaults()
+		return nil
+	}
 
-	CNamespace, err = regexp.Compile("(?i)" + o.Namespace)
-	if err != nil {
+	ctx, cancel := signal.NotifyContext(context.Background(), osSignals...)
+	defer cancel()
+	return startObservers(ctx, targets, o)
+}
+
+// clusterTarget is a cluster to observe, context is empty unless several
+// clusters are observed at once
+type clusterTarget struct {
+	context string
+	client  *k8s.Client
+}
+
+// startObservers observes all the targets with a shared filter, output and
+// limit, until ctx is done or the limit is reached
+func startObservers(ctx context.Context, targets []clusterTarget, o Options) error {
+	if !o.valid() {
+		return errors.New("nothing to observe, check the MsgPath, LogPath and LogFilter options")
+	}
+
+	if err := o.prepare(); err != nil {
 		return err
 	}
-	CLogtype, err = regexp.Compile("(?i)" + o.LogType)
+	defer o.release()
+
+	if o.Record != "" {
+		recorder, err := newRecorder(o.Record, targets)
+		if err != nil {
+			return err
+		}
+		o.recorder = recorder
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				fmt.Fprintf(o.stderr(), "Failed to close the capture file (%s)\n", err.Error())
+			}
+		}()
+	}
+
+	if o.MetricsListen != "" {
+		stopMetrics, err := o.startMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+	}
+
+	stopDelivery, err := o.startDelivery()
 	if err != nil {
 		return err
 	}
-	COperation, err = regexp.Compile("(?i)" + o.Operation)
-	if err != nil {
+	defer stopDelivery()
+
+	// all observers stop with ctx, at the end of the collection window, or
+	// once any of them is done because the shared limit is reached
+	ctx, stopAll := o.collectionContext(ctx)
+	defer stopAll()
+	ctx, stopAll = o.gate.watch(ctx)
+	defer stopAll()
+	o.stop = ctx.Done()
+
+	errCh := make(chan error, len(targets))
+	for _, t := range targets {
+		to := o
+		to.clusterContext = t.context
+		go func(c *k8s.Client) {
+			if to.Enrich {
+				// --namespace is a regex matched by the filter, not a
+				// namespace to list
+				to.enricher = NewEnricher(c, metav1.NamespaceAll)
+				to.enricher.warn = to.stderr()
+				to.enricher.Start(ctx.Done())
+			}
+			var err error
+			if len(to.Files) != 0 {
+				err = observeFiles(to)
+			} else if to.LogSource == SourcePods {
+				err = observePods(c, to)
+			} else if to.Direct {
+				err = observeDirect(c, to)
+			} else {
+				err = observeCluster(c, to)
+			}
+			if err == nil {
+				stopAll()
+			} else if to.clusterContext != "" {
+				err = fmt.Errorf("context %s: %w", to.clusterContext, err)
+				fmt.Fprintln(to.stderr(), err.Error())
+			}
+			errCh <- err
+		}(t.client)
+	}
+
+	var errs []error
+	for range targets {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	stopAll()
+	if len(errs) == 1 {
+		return o.gate.result(errs[0])
+	}
+	return o.gate.result(errors.Join(errs...))
+}
+
+// watchAlerts reports whether the alert stream is watched
+func (o Options) watchAlerts() bool {
+	return o.watchTelemetry() && (o.LogFilter == "all" || o.LogFilter == "policy")
+}
+
+// watchLogs reports whether the log stream is watched, for output or to
+// feed the process tree
+func (o Options) watchLogs() bool {
+	return o.watchTelemetry() && (o.LogFilter == "all" || o.LogFilter == "system" || o.ProcessTree)
+}
+
+// treeOnlyLogs reports whether logs only feed the process tree and are not
+// written out
+func (o Options) treeOnlyLogs() bool {
+	return o.ProcessTree && o.LogFilter == "policy"
+}
+
+// collectionContext bounds ctx by Duration, Timeout and Until
+func (o Options) collectionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	if d := o.timeout(); d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	if !o.Until.IsZero() && (deadline.IsZero() || o.Until.Before(deadline)) {
+		deadline = o.Until
+	}
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// timeout returns the shorter of Duration and Timeout, 0 if none is set
+func (o Options) timeout() time.Duration {
+	if o.Timeout > 0 && (o.Duration <= 0 || o.Timeout < o.Duration) {
+		return o.Timeout
+	}
+	return o.Duration
+}
+
+// valid reports whether there is anything to watch with a known filter
+func (o Options) valid() bool {
+	if o.MsgPath == "none" && !o.watchTelemetry() {
+		return false
+	}
+	return o.LogFilter == "all" || o.LogFilter == "policy" || o.LogFilter == "system"
+}
+
+// prepare compiles the filter and opens the sinks shared by all the
+// observers, release undoes it
+func (o *Options) prepare() error {
+	if _, err := GetFormatter(o.outputFormat()); err != nil {
 		return err
 	}
-	CContainerName, err = regexp.Compile("(?i)" + o.ContainerName)
-	if err != nil {
+	if err := ValidOverflow(o.Overflow); err != nil {
 		return err
 	}
-	CPodName, err = regexp.Compile("(?i)" + o.PodName)
+	if o.LogSource != "" && o.LogSource != SourceRelay && o.LogSource != SourcePods {
+		return fmt.Errorf("unknown log source %q, use %s or %s", o.LogSource, SourceRelay, SourcePods)
+	}
+	if o.Direct && (o.LogSource == SourcePods || o.GRPC != "") {
+		return errors.New("--direct cannot be combined with --from=pods or --gRPC")
+	}
+	if len(o.Nodes) != 0 && !o.Direct {
+		return errors.New("--nodes needs --direct")
+	}
+	if len(o.Files) != 0 && (o.Direct || o.LogSource == SourcePods || o.GRPC != "") {
+		return errors.New("--file cannot be combined with --direct, --from=pods or --gRPC")
+	}
+	if o.Follow && len(o.Files) == 0 {
+		return errors.New("--follow needs --file")
+	}
+
+	if o.filter == nil {
+		flt, err := NewOptionsFilter(*o)
+		if err != nil {
+			return err
+		}
+		o.filter = flt
+	}
+
+	sinks, err := NewSinks(*o)
 	if err != nil {
 		return err
 	}
-	CSource, err = regexp.Compile(o.Source)
+	o.sinks = sinks
+
+	if o.Aggregate > 0 {
+		agg, err := newAggregator(*o)
+		if err != nil {
+			CloseSinks(o.sinks, o.stderr())
+			return err
+		}
+		o.agg = agg
+	}
+
+	if o.ProcessTree {
+		o.tree = NewProcessTree()
+	}
+
+	redactor, err := newRedactor(*o)
 	if err != nil {
+		o.release()
 		return err
 	}
-	CResource, err = regexp.Compile(o.Resource)
+	o.redactor = redactor
+
+	gate, err := newGate(*o)
 	if err != nil {
+		o.release()
 		return err
 	}
-	return nil
-}
+	o.gate = gate
 
-func closeStopChan() {
-	if StopChan == nil {
-		return
+	if o.ExecOnAlert != "" {
+		hook, err := newAlertHook(*o)
+		if err != nil {
+			o.release()
+			return err
+		}
+		o.hook = hook
 	}
-	close(StopChan)
-	StopChan = nil
-}
 
-// StartObserver Function
-func StartObserver(c *k8s.Client, o Options) error {
-	gRPC := ""
-	targetSvc := "kubearmor-relay"
-
-	if o.GRPC != "" {
-		gRPC = o.GRPC
-	} else if val, ok := os.LookupEnv("KUBEARMOR_SERVICE"); ok {
-		gRPC = val
-	} else {
-		pf, err := utils.InitiatePortForward(c, port, port, matchLabels, targetSvc)
+	if o.Store != "" {
+		store, err := OpenEventStore(o.Store, o.StoreRetention, o.StoreMaxEvents)
 		if err != nil {
+			o.release()
 			return err
 		}
-		gRPC = "localhost:" + strconv.FormatInt(pf.LocalPort, 10)
+		store.warn = o.stderr()
+		o.store = store
 	}
 
-	if o.MsgPath == "none" && o.LogPath == "none" {
-		flag.PrintDefaults()
-		return nil
+	if o.Report != "" {
+		// created last, the report is written once released
+		if err := ValidReportFormat(o.ReportFormat); err != nil {
+			o.release()
+			return err
+		}
+		report, err := NewTagReport(o.Report)
+		if err != nil {
+			o.release()
+			return err
+		}
+		o.report = report
 	}
 
-	if o.LogFilter != "all" && o.LogFilter != "policy" && o.LogFilter != "system" {
-		flag.PrintDefaults()
-		return nil
+	o.counters = &streamCounters{}
+	return nil
+}
+
+func (o *Options) release() {
+	o.hook.close()
+	o.store.Close()
+	if o.agg != nil {
+		o.agg.close()
 	}
+	o.report.close(*o)
+	CloseSinks(o.sinks, o.stderr())
+}
 
-	// create client
-	logClient, err := NewClient(gRPC, o, c.K8sClientset)
+// observeCluster connects to KubeArmor in a cluster and watches it,
+// reconnecting if the streams drop
+func observeCluster(c *k8s.Client, o Options) error {
+	logClient, release, err := connect(c, &o)
 	if err != nil {
-		if !o.Secure && !isDialingError(err) {
-			// retry connecting to the server on secured channel
-			fmt.Fprintf(os.Stderr, "Failed to connect on insecure channel\n(%s)\n", err)
-			fmt.Fprint(os.Stderr, "Trying to reconnect using secured channel...\n")
-			o.Secure = true
-			logClient, err = NewClient(gRPC, o, c.K8sClientset)
-			if err != nil {
-				return fmt.Errorf("unable to create log client, error=%s", err)
-			}
-		} else {
-			return fmt.Errorf("unable to create log client, error=%s", err)
+		return err
+	}
+	o.metrics.setConnected(o.clusterContext, true)
+
+	for {
+		err = observe(logClient, o)
+		release()
+		o.metrics.setConnected(o.clusterContext, false)
+
+		if err == nil {
+			// interrupted or --limit reached
+			return nil
 		}
+
+		logClient, release, err = reconnect(c, &o, err)
+		if err != nil {
+			return err
+		}
+		if logClient == nil {
+			// interrupted while waiting to reconnect
+			return nil
+		}
+		o.metrics.reconnected(o.clusterContext)
 	}
+}
 
-	fmt.Fprintf(os.Stderr, "Created a gRPC client (%s)\n", gRPC)
+// observe watches the streams of the client until interrupted, --limit is
+// reached (both return nil) or a stream fails. It destroys the client and
+// waits for the watchers before returning.
+func observe(logClient *Feeder, o Options) error {
+	results := make(chan error, 3)
 
-	// do healthcheck
-	if ok := logClient.DoHealthCheck(); !ok {
-		return errors.New("failed to check the liveness of the gRPC server")
+	var wg sync.WaitGroup
+	watch := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- fn()
+		}()
+		fmt.Fprintf(o.stderr(), "Started to watch %s\n", name)
 	}
-	fmt.Fprintln(os.Stderr, "Checked the liveness of the gRPC server")
+	defer func() {
+		fmt.Fprintln(o.stderr(), "releasing grpc client")
+		_ = logClient.DestroyClient()
+		wg.Wait()
+	}()
 
 	if o.MsgPath != "none" {
-		// watch messages
-		go logClient.WatchMessages(o.MsgPath, o.JSON)
-		fmt.Fprintln(os.Stderr, "Started to watch messages")
+		watch("messages", func() error {
+			return logClient.WatchMessages(o.MsgPath, o.JSON)
+		})
 	}
 
-	err = regexCompile(o)
-	if err != nil {
-		fmt.Print(err)
-		return err
+	pending := 0
+	if o.watchAlerts() {
+		pending++
+		watch("alerts", func() error {
+			return logClient.WatchAlerts(o)
+		})
 	}
-
-	Limitchan = make(chan bool, 2)
-	if o.LogPath != "none" {
-		if o.LogFilter == "all" || o.LogFilter == "policy" {
-			// watch alerts
-			go logClient.WatchAlerts(o)
-			fmt.Fprintln(os.Stderr, "Started to watch alerts")
-		}
-
-		if o.LogFilter == "all" || o.LogFilter == "system" {
-			// watch logs
-			go logClient.WatchLogs(o)
-			fmt.Fprintln(os.Stderr, "Started to watch logs")
+	if o.watchLogs() {
+		if !o.treeOnlyLogs() {
+			pending++
 		}
+		watch("logs", func() error {
+			return logClient.WatchLogs(o)
+		})
 	}
 
-	ctrlc := false
-	if o.Limit != 0 {
-		<-Limitchan
-		if o.LogFilter == "all" {
-			<-Limitchan
-		}
-	} else {
-		// listen for interrupt signals
-		UnblockSignal = nil
-		sigChan = GetOSSigChannel()
-		for UnblockSignal == nil && !ctrlc {
-			time.Sleep(50 * time.Millisecond)
-			select {
-			case <-sigChan:
-				ctrlc = true
-			default:
+	for {
+		select {
+		case <-o.stop:
+			return nil
+		case err := <-results:
+			if err != nil {
+				return err
+			}
+			// a telemetry watcher reached the limit
+			pending--
+			if o.Limit != 0 && pending <= 0 {
+				return nil
 			}
 		}
 	}
-	fmt.Fprintln(os.Stderr, "releasing grpc client")
-	closeStopChan()
-
-	logClient.Running = false
-
-	// destroy the client
-	_ = logClient.DestroyClient()
-	if ctrlc {
-		return nil
-	}
-	return UnblockSignal
 }
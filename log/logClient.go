@@ -4,21 +4,18 @@
 package log
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	pb "github.com/kubearmor/KubeArmor/protobuf"
-	"golang.org/x/exp/slices"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -31,12 +28,6 @@ type EventInfo struct {
 	Type string // "Alert"/"Log"
 }
 
-// Limitchan handles telemetry event output limit
-var (
-	Limitchan chan bool
-	i         uint32
-)
-
 // ============ //
 // == Common == //
 // ============ //
@@ -103,6 +94,18 @@ type Feeder struct {
 
 	// wait group
 	WgClient sync.WaitGroup
+
+	// set once the client is being destroyed
+	stopped atomic.Bool
+
+	// records received events with --record
+	record func(CaptureRecord)
+
+	// from --redact, applied before recording
+	redactor *redactor
+
+	// where the messages of the client go
+	stderr io.Writer
 }
 
 // NewClient Function
@@ -115,8 +118,14 @@ func NewClient(server string, o Options, c kubernetes.Interface) (*Feeder, error
 
 	fd.limit = o.Limit
 
+	fd.record = o.record
+
+	fd.redactor = o.redactor
+
+	fd.stderr = o.stderr()
+
 	var creds credentials.TransportCredentials
-	if o.Secure {
+	if o.Secure || o.TLS.Enabled() {
 		tlsCreds, err := loadTLSCredentials(c, o)
 		if err != nil {
 			return nil, err
@@ -127,7 +136,7 @@ func NewClient(server string, o Options, c kubernetes.Interface) (*Feeder, error
 	}
 	conn, err := grpc.Dial(fd.server, grpc.WithTransportCredentials(creds))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error dialing the server: %s", err)
+		fmt.Fprintf(fd.stderr, "Error dialing the server: %s", err)
 		return nil, err
 	}
 	fd.conn = conn
@@ -148,7 +157,7 @@ func NewClient(server string, o Options, c kubernetes.Interface) (*Feeder, error
 	alertIn := pb.RequestMessage{}
 	alertIn.Filter = o.LogFilter
 
-	if o.LogPath != "none" && (alertIn.Filter == "all" || alertIn.Filter == "policy") {
+	if o.watchAlerts() {
 		alertStream, err := fd.client.WatchAlerts(context.Background(), &alertIn)
 		if err != nil {
 			return nil, err
@@ -158,8 +167,11 @@ func NewClient(server string, o Options, c kubernetes.Interface) (*Feeder, error
 
 	logIn := pb.RequestMessage{}
 	logIn.Filter = o.LogFilter
+	if o.treeOnlyLogs() {
+		logIn.Filter = "system"
+	}
 
-	if o.LogPath != "none" && (logIn.Filter == "all" || logIn.Filter == "system") {
+	if o.watchLogs() {
 		logStream, err := fd.client.WatchLogs(context.Background(), &logIn)
 		if err != nil {
 			return nil, err
@@ -197,313 +209,286 @@ func (fd *Feeder) WatchMessages(msgPath string, jsonFormat bool) error {
 	fd.WgClient.Add(1)
 	defer fd.WgClient.Done()
 
-	for fd.Running {
+	for fd.running() {
 		res, err := fd.msgStream.Recv()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to receive a message (%s)\n", err.Error())
-			UnblockSignal = err
-			break
-		}
-
-		str := ""
-
-		if jsonFormat {
-			arr, _ := json.Marshal(res)
-			str = fmt.Sprintf("%s\n", string(arr))
-		} else {
-			updatedTime := strings.Replace(res.UpdatedTime, "T", " ", -1)
-			updatedTime = strings.Replace(updatedTime, "Z", "", -1)
-
-			str = fmt.Sprintf("%s  %s  %s  [%s]  %s\n", updatedTime, res.ClusterName, res.HostName, res.Level, res.Message)
+			if !fd.running() {
+				break
+			}
+			fmt.Fprintf(fd.stderr, "Failed to receive a message (%s)\n", err.Error())
+			return err
 		}
+		fd.redactor.redactMessage(res)
+		fd.record(CaptureRecord{Message: res})
 
-		if msgPath == "stdout" {
-			fmt.Printf("%s", str)
-		} else if msgPath != "" {
-			StrToFile(str, msgPath)
-		}
+		writeMessage(res, msgPath, jsonFormat)
 	}
 
-	fmt.Fprintln(os.Stderr, "Stopped WatchMessages")
+	fmt.Fprintln(fd.stderr, "Stopped WatchMessages")
 
 	return nil
 }
 
-func regexMatcher(filter *regexp.Regexp, res string) bool {
-	match := filter.MatchString(res)
-	if !match {
-		return false
+// writeMessage prints a KubeArmor message to msgPath
+func writeMessage(res *pb.Message, msgPath string, jsonFormat bool) {
+	str := ""
+
+	if jsonFormat {
+		arr, _ := json.Marshal(res)
+		str = fmt.Sprintf("%s\n", string(arr))
+	} else {
+		updatedTime := strings.Replace(res.UpdatedTime, "T", " ", -1)
+		updatedTime = strings.Replace(updatedTime, "Z", "", -1)
+
+		str = fmt.Sprintf("%s  %s  %s  [%s]  %s\n", updatedTime, res.ClusterName, res.HostName, res.Level, res.Message)
+	}
+
+	if msgPath == "stdout" {
+		fmt.Printf("%s", str)
+	} else if msgPath != "" {
+		StrToFile(str, msgPath)
 	}
-	return true
 }
 
 // WatchAlerts Function
+//
+// It returns nil once --limit alerts were received or the client is
+// destroyed, and the stream error if the connection is lost.
 func (fd *Feeder) WatchAlerts(o Options) error {
 	fd.WgClient.Add(1)
 	defer fd.WgClient.Done()
 
-	if o.Limit > 0 {
-		for i = 0; i < o.Limit; i++ {
-			res, err := fd.alertStream.Recv()
-			if err != nil {
-				break
-			}
-
-			t, _ := json.Marshal(res)
-			WatchTelemetryHelper(t, "Alert", o)
-
+	count := o.counters.get("Alert")
+	for fd.running() {
+		if o.Limit > 0 && count.Load() >= o.Limit {
+			return nil
 		}
-		Limitchan <- true
-		return nil
-	}
-	for fd.Running {
 		res, err := fd.alertStream.Recv()
 		if err != nil {
-			UnblockSignal = err
-			break
+			if !fd.running() {
+				break
+			}
+			return err
+		}
+		o.metrics.received(o.clusterContext)
+		if !takeSlot(count, o.Limit) {
+			// the limit was reached concurrently on another cluster
+			return nil
+		}
+		if !o.deliverAlert(res) {
+			return nil
 		}
-
-		t, _ := json.Marshal(res)
-		WatchTelemetryHelper(t, "Alert", o)
-
 	}
 
-	fmt.Fprintln(os.Stderr, "Stopped WatchAlerts")
+	fmt.Fprintln(fd.stderr, "Stopped WatchAlerts")
 
 	return nil
 }
 
 // WatchLogs Function
+//
+// It returns nil once --limit logs were received or the client is
+// destroyed, and the stream error if the connection is lost.
 func (fd *Feeder) WatchLogs(o Options) error {
 	fd.WgClient.Add(1)
 	defer fd.WgClient.Done()
 
-	if o.Limit > 0 {
-		for i = 0; i < o.Limit; i++ {
-			res, err := fd.logStream.Recv()
-			if err != nil {
+	count := o.counters.get("Log")
+	treeOnly := o.treeOnlyLogs()
+	for fd.running() {
+		if !treeOnly && o.Limit > 0 && count.Load() >= o.Limit {
+			return nil
+		}
+		res, err := fd.logStream.Recv()
+		if err != nil {
+			if !fd.running() {
 				break
 			}
-
+			return err
+		}
+		if treeOnly {
 			t, _ := json.Marshal(res)
-			WatchTelemetryHelper(t, "Log", o)
-
+			handleTelemetry(t, "Log", o)
+			continue
 		}
-		Limitchan <- true
-		return nil
-	}
-	for fd.Running {
-		res, err := fd.logStream.Recv()
-		if err != nil {
-			UnblockSignal = err
-			break
+		o.metrics.received(o.clusterContext)
+		if !takeSlot(count, o.Limit) {
+			// the limit was reached concurrently on another cluster
+			return nil
+		}
+		if !o.deliverLog(res) {
+			return nil
 		}
-
-		t, _ := json.Marshal(res)
-		WatchTelemetryHelper(t, "Log", o)
 	}
 
-	fmt.Fprintln(os.Stderr, "Stopped WatchLogs")
+	fmt.Fprintln(fd.stderr, "Stopped WatchLogs")
 
 	return nil
 }
 
-// WatchTelemetryHelper handles Alerts and Logs
-func WatchTelemetryHelper(arr []byte, t string, o Options) {
-	var res map[string]interface{}
-	err := json.Unmarshal(arr, &res)
-	if err != nil {
-		return
+// deliverAlert redacts, records and handles an alert that took a slot of the
+// limit, and hands it to the Observer if it passes the filter. It returns
+// false once stopped.
+func (o Options) deliverAlert(res *pb.Alert) bool {
+	// secrets go nowhere, not even to the capture
+	o.redactor.redactAlert(res)
+	o.record(CaptureRecord{Alert: res})
+
+	t, _ := json.Marshal(res)
+	if !handleTelemetry(t, "Alert", o) {
+		return true
 	}
-	// Filter Telemetry based on provided options
-	if len(o.Selector) != 0 && res["Labels"] != nil {
-		labels := strings.Split(res["Labels"].(string), ",")
-		val := selectLabels(o, labels)
-		if val != nil {
-			return
-		}
+	if o.alertQueue != nil {
+		return o.alertQueue.Send(res)
 	}
-
-	if o.Namespace != "" {
-		ns, ok := res["NamespaceName"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(CNamespace, ns)
-		if !match {
-			return
+	if o.alertChan != nil {
+		select {
+		case o.alertChan <- res:
+		case <-o.stop:
+			return false
 		}
 	}
+	return true
+}
 
-	if o.LogType != "" {
-		t, ok := res["Type"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(CLogtype, t)
-		if !match {
-			return
-		}
-	}
+// deliverLog is deliverAlert for logs
+func (o Options) deliverLog(res *pb.Log) bool {
+	o.redactor.redactLog(res)
+	o.record(CaptureRecord{Log: res})
 
-	if o.Operation != "" {
-		op, ok := res["Operation"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(COperation, op)
-		if !match {
-			return
+	t, _ := json.Marshal(res)
+	if !handleTelemetry(t, "Log", o) {
+		return true
+	}
+	if o.logQueue != nil {
+		return o.logQueue.Send(res)
+	}
+	if o.logChan != nil {
+		select {
+		case o.logChan <- res:
+		case <-o.stop:
+			return false
 		}
 	}
+	return true
+}
 
-	if o.ContainerName != "" {
-		cn, ok := res["ContainerName"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(CContainerName, cn)
-		if !match {
-			return
-		}
+// WatchTelemetryHelper handles Alerts and Logs
+func WatchTelemetryHelper(arr []byte, t string, o Options) {
+	handleTelemetry(arr, t, o)
+}
+
+// handleTelemetry filters, signals and writes an alert or log, it returns
+// whether the event passed the filter
+func handleTelemetry(arr []byte, t string, o Options) bool {
+	if o.daemon != nil {
+		arr = withNodeName(arr, o.daemon.Spec.NodeName)
+	}
+	if o.clusterContext != "" {
+		arr = withClusterContext(arr, o.clusterContext)
 	}
 
-	if o.PodName != "" {
-		pn, ok := res["PodName"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(CPodName, pn)
-		if !match {
-			return
-		}
+	var res map[string]interface{}
+	err := json.Unmarshal(arr, &res)
+	if err != nil {
+		return false
 	}
 
-	if o.Source != "" {
-		src, ok := res["Source"].(string)
-		if !ok {
-			return
+	if o.tree != nil {
+		// every process seen helps reconstructing the exec chains
+		o.tree.Add(res)
+		if t == "Log" && o.treeOnlyLogs() {
+			return false
 		}
-		match := regexMatcher(CSource, src)
-		if !match {
-			return
+		if t == "Alert" {
+			if chain := o.tree.Chain(res); chain != "" {
+				arr = withField(arr, "ProcessTree", chain)
+				res["ProcessTree"] = chain
+			}
 		}
 	}
-
-	if o.Resource != "" {
-		rs, ok := res["Resource"].(string)
-		if !ok {
-			return
-		}
-		match := regexMatcher(CResource, rs)
-		if !match {
-			return
+	if o.enricher != nil && t == "Alert" {
+		arr = o.enrich(arr, res)
+	}
+	// Filter Telemetry based on provided options
+	flt := o.filter
+	if flt == nil {
+		flt, err = NewOptionsFilter(o)
+		if err != nil {
+			fmt.Fprintf(o.stderr(), "Failed to compile filter (%s)\n", err.Error())
+			return false
 		}
 	}
-
-	str := ""
+	if !flt.Match(res) {
+		return false
+	}
+	// before anything is written out
+	arr = o.redactor.redact(arr, res)
+	o.gate.observe(res, arr)
+	if t == "Alert" {
+		o.hook.submit(res, arr)
+	}
+	o.store.add(t, res, arr)
+	o.report.Add(t, res)
 
 	// Pass Events to Channel for further handling
-	if o.EventChan != nil {
+	if o.events != nil {
+		o.events.Send(EventInfo{Data: arr, Type: t})
+	} else if o.EventChan != nil {
 		o.EventChan <- EventInfo{Data: arr, Type: t}
 	}
 
-	if o.JSON || o.Output == "json" {
-		str = fmt.Sprintf("%s\n", string(arr))
-	} else if o.Output == "pretty-json" {
-
-		var prettyJSON bytes.Buffer
-		err = json.Indent(&prettyJSON, arr, "", "  ")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to prettify JSON (%s)\n", err.Error())
-		}
-		str = fmt.Sprintf("%s\n", prettyJSON.String())
-
-	} else {
-
-		if time, ok := res["UpdatedTime"]; ok {
-			updatedTime := strings.Replace(time.(string), "T", " ", -1)
-			updatedTime = strings.Replace(updatedTime, "Z", "", -1)
-			str = fmt.Sprintf("== %s / %s ==\n", t, updatedTime)
-		} else {
-			str = fmt.Sprintf("== %s ==\n", t)
-		}
+	if o.agg != nil {
+		// rolled up and written once per window instead
+		o.agg.add(t, res, arr)
+		return true
+	}
 
-		// Array of Keys to preserve order in Output
-		telKeys := []string{
-			"UpdatedTime",
-			"Timestamp",
-			"ClusterName",
-			"HostName",
-			"NamespaceName",
-			"PodName",
-			"Labels",
-			"ContainerName",
-			"ContainerID",
-			"ContainerImage",
-			"Type",
-			"PolicyName",
-			"Severity",
-			"Message",
-			"Source",
-			"Resource",
-			"Operation",
-			"Action",
-			"Data",
-			"EventData",
-			"Enforcer",
-			"Result",
-		}
+	format, err := GetFormatter(o.outputFormat())
+	if err != nil {
+		format = formatText
+	}
+	str, err := format(t, res, arr)
+	if err != nil {
+		fmt.Fprintf(o.stderr(), "Failed to format %s (%s)\n", t, err.Error())
+		return true
+	}
+	o.emit(t, arr, str)
+	return true
+}
 
-		var additionalKeys []string
-		// Looping through the Map to find additional keys not present in our array
-		for k := range res {
-			if !slices.Contains(telKeys, k) {
-				additionalKeys = append(additionalKeys, k)
-			}
-		}
-		sort.Strings(additionalKeys)
-		telKeys = append(telKeys, additionalKeys...)
-
-		for i := 2; i < len(telKeys); i++ { // Starting the loop from index 2 to skip printing timestamp again
-			k := telKeys[i]
-			// Check if fields are present in the structure and if present verifying that they are not empty
-			// Certain fields like Container* are not present in HostLogs, this check handles that and other edge cases
-			if v, ok := res[k]; ok && v != "" {
-				if _, ok := res[k].(float64); ok {
-					str = str + fmt.Sprintf("%s: %.0f\n", k, res[k])
-				} else {
-					str = str + fmt.Sprintf("%s: %v\n", k, res[k])
-				}
-			}
+// emit writes a formatted event to the outputs
+func (o Options) emit(t string, arr []byte, str string) {
+	if o.sinks == nil {
+		// not started through StartObserver, write to LogPath directly
+		if o.LogPath == "stdout" {
+			fmt.Printf("%s", str)
+		} else if o.LogPath != "" && o.LogPath != "none" {
+			StrToFile(str, o.LogPath)
 		}
+		return
 	}
-
-	if o.LogPath == "stdout" {
-		fmt.Printf("%s", str)
-	} else if o.LogPath != "" {
-		StrToFile(str, o.LogPath)
+	for _, s := range o.sinks {
+		if err := s.Write(SinkEvent{Type: t, Data: arr, Text: str}); err != nil {
+			fmt.Fprintf(o.stderr(), "Failed to write %s to sink (%s)\n", t, err.Error())
+		}
 	}
 }
 
 // DestroyClient Function
 func (fd *Feeder) DestroyClient() error {
+	fd.stopped.Store(true)
 	if err := fd.conn.Close(); err != nil {
 		return err
 	}
 	fd.WgClient.Wait()
+	fd.Running = false
 	return nil
 }
 
-func selectLabels(o Options, labels []string) error {
-	for _, val := range o.Selector {
-		for _, label := range labels {
-			if val == label {
-				return nil
-			}
-		}
-	}
-	return errors.New("Not found any flag")
+// running reports whether the watchers should keep receiving
+func (fd *Feeder) running() bool {
+	return !fd.stopped.Load()
 }
 
 func isDialingError(err error) bool {
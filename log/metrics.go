@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics counts the observed telemetry and the health of the streams in
+// the Prometheus format
+type Metrics struct {
+	registry *prometheus.Registry
+
+	alerts *prometheus.CounterVec
+	logs   *prometheus.CounterVec
+
+	connected  *prometheus.GaugeVec
+	reconnects *prometheus.CounterVec
+	lastEvent  *prometheus.GaugeVec
+}
+
+// NewMetrics Function
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		alerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "karmor_alerts_total",
+			Help: "Number of KubeArmor alerts observed.",
+		}, []string{"policy", "severity", "action", "namespace", "operation"}),
+		logs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "karmor_logs_total",
+			Help: "Number of KubeArmor system logs observed.",
+		}, []string{"operation", "result"}),
+		connected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "karmor_stream_connected",
+			Help: "Whether the client is connected to the KubeArmor relay (1) or not (0).",
+		}, []string{"context"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "karmor_stream_reconnects_total",
+			Help: "Number of successful reconnects to the KubeArmor relay.",
+		}, []string{"context"}),
+		lastEvent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "karmor_stream_last_event_timestamp_seconds",
+			Help: "Unix time of the last alert or log received from the KubeArmor relay.",
+		}, []string{"context"}),
+	}
+	m.registry.MustRegister(m.alerts, m.logs, m.connected, m.reconnects, m.lastEvent)
+	return m
+}
+
+// Handler serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Observe counts an event signalled on EventChan
+func (m *Metrics) Observe(ev EventInfo) {
+	var res map[string]interface{}
+	if err := json.Unmarshal(ev.Data, &res); err != nil {
+		return
+	}
+	switch ev.Type {
+	case "Alert":
+		m.alerts.WithLabelValues(fieldString(res, "PolicyName"), fieldString(res, "Severity"),
+			fieldString(res, "Action"), fieldString(res, "NamespaceName"), fieldString(res, "Operation")).Inc()
+	case "Log":
+		m.logs.WithLabelValues(fieldString(res, "Operation"), fieldString(res, "Result")).Inc()
+	}
+}
+
+// setConnected records whether the stream of a context is connected
+func (m *Metrics) setConnected(clusterContext string, connected bool) {
+	if m == nil {
+		return
+	}
+	v := 0.0
+	if connected {
+		v = 1
+	}
+	m.connected.WithLabelValues(clusterContext).Set(v)
+}
+
+// reconnected records a successful reconnect of the stream of a context
+func (m *Metrics) reconnected(clusterContext string) {
+	if m == nil {
+		return
+	}
+	m.reconnects.WithLabelValues(clusterContext).Inc()
+	m.setConnected(clusterContext, true)
+}
+
+// received records the arrival of an event, before it is filtered
+func (m *Metrics) received(clusterContext string) {
+	if m == nil {
+		return
+	}
+	m.lastEvent.WithLabelValues(clusterContext).Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+// startMetrics serves the metrics on MetricsListen and counts the events
+// passed on EventChan, forwarding them to the caller's EventChan if any. The
+// returned function stops serving once all observers are done.
+func (o *Options) startMetrics() (func(), error) {
+	lis, err := net.Listen("tcp", o.MetricsListen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics on %s (%w)", o.MetricsListen, err)
+	}
+
+	metrics := NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(o.stderr(), "Failed to serve metrics (%s)\n", err.Error())
+		}
+	}()
+	fmt.Fprintf(o.stderr(), "Serving metrics on http://%s/metrics\n", lis.Addr().String())
+
+	events := make(chan EventInfo, 1024)
+	forward := o.EventChan
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			metrics.Observe(ev)
+			if forward != nil {
+				forward <- ev
+			}
+		}
+	}()
+	o.EventChan = events
+	o.metrics = metrics
+
+	return func() {
+		close(events)
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,59 @@
+package log
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	alert, _ := json.Marshal(formatAlert)
+	m.Observe(EventInfo{Type: "Alert", Data: alert})
+	m.Observe(EventInfo{Type: "Alert", Data: alert})
+	m.Observe(EventInfo{Type: "Log", Data: []byte(`{"Operation":"Process","Result":"Passed"}`)})
+	m.setConnected("", true)
+	m.reconnected("")
+	m.received("")
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`karmor_alerts_total{action="Block",namespace="prod",operation="File",policy="block-shadow",severity="7"} 2`,
+		`karmor_logs_total{operation="Process",result="Passed"} 1`,
+		`karmor_stream_connected{context=""} 1`,
+		`karmor_stream_reconnects_total{context=""} 1`,
+		`karmor_stream_last_event_timestamp_seconds{context=""}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsForwardsEvents(t *testing.T) {
+	srv := &fakeLogServer{perStream: 5}
+	addr := startFakeLogServer(t, srv)
+
+	events := make(chan EventInfo, 100)
+	err := runObserver(t, Options{
+		GRPC:          addr,
+		MsgPath:       "none",
+		LogPath:       "none",
+		LogFilter:     "policy",
+		Limit:         3,
+		RetryBackoff:  time.Millisecond,
+		MetricsListen: "127.0.0.1:0",
+		EventChan:     events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Errorf("expected 3 alerts forwarded to EventChan, got %d", len(events))
+	}
+}
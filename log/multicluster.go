@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+)
+
+// StartMultiClusterObserver observes the KubeArmor relays of several
+// kubeconfig contexts at once and merges their events into one output. Every
+// event carries the context it came from in its ClusterContext field.
+func StartMultiClusterObserver(contexts []string, o Options) error {
+	if len(contexts) == 0 {
+		return errors.New("no kubeconfig contexts to observe")
+	}
+	if o.GRPC != "" {
+		return errors.New("--gRPC cannot be combined with multiple contexts")
+	}
+
+	targets := make([]clusterTarget, 0, len(contexts))
+	for _, ctx := range contexts {
+		c, err := k8s.ConnectK8sClientForContext(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to create Kubernetes clients for context %s: %w", ctx, err)
+		}
+		targets = append(targets, clusterTarget{context: ctx, client: c})
+	}
+	return observeUntilSignal(targets, o)
+}
+
+// withClusterContext adds the ClusterContext field to a JSON encoded event
+func withClusterContext(arr []byte, context string) []byte {
+	return withField(arr, "ClusterContext", context)
+}
+
+// withField adds a string field to the front of a JSON encoded event
+func withField(arr []byte, key, value string) []byte {
+	if len(arr) < 2 || arr[0] != '{' {
+		return arr
+	}
+	quoted, err := json.Marshal(value)
+	if err != nil {
+		return arr
+	}
+	field := `"` + key + `":` + string(quoted)
+	out := make([]byte, 0, len(arr)+len(field)+1)
+	out = append(out, '{')
+	out = append(out, field...)
+	if len(arr) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, arr[1:]...)
+}
@@ -0,0 +1,70 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+)
+
+func TestWithClusterContext(t *testing.T) {
+	for in, want := range map[string]string{
+		`{}`:                  `{"ClusterContext":"kind-\"a\""}`,
+		`{"PodName":"nginx"}`: `{"ClusterContext":"kind-\"a\"","PodName":"nginx"}`,
+	} {
+		if got := string(withClusterContext([]byte(in), `kind-"a"`)); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestMultiClusterFanIn(t *testing.T) {
+	srv := &fakeLogServer{perStream: 3}
+	addr := startFakeLogServer(t, srv)
+
+	events := make(chan EventInfo, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		// both contexts reach the same fake relay
+		targets := []clusterTarget{
+			{context: "eu", client: &k8s.Client{}},
+			{context: "us", client: &k8s.Client{}},
+		}
+		errCh <- startObservers(context.Background(), targets, Options{
+			GRPC:         addr,
+			MsgPath:      "none",
+			LogFilter:    "policy",
+			Filter:       "ClusterContext in (eu, us)",
+			Limit:        10,
+			MaxRetries:   5,
+			RetryBackoff: time.Millisecond,
+			EventChan:    events,
+		})
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("observers did not stop")
+	}
+
+	if len(events) != 10 {
+		t.Fatalf("expected the limit of 10 alerts across clusters, got %d", len(events))
+	}
+	seen := map[string]int{}
+	for len(events) > 0 {
+		var res map[string]interface{}
+		if err := json.Unmarshal((<-events).Data, &res); err != nil {
+			t.Fatal(err)
+		}
+		ctx, _ := res["ClusterContext"].(string)
+		seen[ctx]++
+	}
+	if seen["eu"] == 0 || seen["us"] == 0 || seen["eu"]+seen["us"] != 10 {
+		t.Errorf("unexpected events per context %v", seen)
+	}
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/k8s"
+)
+
+// observerBuffer is the capacity of the typed channels of an Observer
+const observerBuffer = 64
+
+// Observer watches the telemetry of KubeArmor for programs embedding the
+// client. Unlike StartObserver it does not handle OS signals, it runs until
+// its context is done, Close is called or Options.Limit is reached.
+//
+// Alerts and logs that pass the filters are written to the outputs of the
+// Options as with `karmor logs`, and delivered on the typed channels
+// returned by Alerts and Logs. Both must be requested before Run and drained
+// while it runs, as the streams are paused while a channel is full.
+type Observer struct {
+	opts    Options
+	targets []clusterTarget
+
+	mu      sync.Mutex
+	alerts  chan *pb.Alert
+	logs    chan *pb.Log
+	started bool
+	closed  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewObserver creates an observer of the KubeArmor relay of a cluster,
+// compiling its filters
+func NewObserver(c *k8s.Client, o Options) (*Observer, error) {
+	if _, err := GetFormatter(o.outputFormat()); err != nil {
+		return nil, err
+	}
+	flt, err := NewOptionsFilter(o)
+	if err != nil {
+		return nil, err
+	}
+	o.filter = flt
+
+	return &Observer{
+		opts:    o,
+		targets: []clusterTarget{{client: c}},
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Alerts returns the channel of the alerts that pass the filters. It is
+// closed once Run returns.
+func (ob *Observer) Alerts() <-chan *pb.Alert {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.alerts == nil {
+		if ob.started {
+			// requested too late to be fed
+			c := make(chan *pb.Alert)
+			close(c)
+			return c
+		}
+		ob.alerts = make(chan *pb.Alert, observerBuffer)
+	}
+	return ob.alerts
+}
+
+// Logs returns the channel of the system logs that pass the filters. It is
+// closed once Run returns.
+func (ob *Observer) Logs() <-chan *pb.Log {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.logs == nil {
+		if ob.started {
+			// requested too late to be fed
+			c := make(chan *pb.Log)
+			close(c)
+			return c
+		}
+		ob.logs = make(chan *pb.Log, observerBuffer)
+	}
+	return ob.logs
+}
+
+// Run observes until ctx is done, Close is called or the limit is reached,
+// reconnecting if the streams drop. An observer can only be run once.
+func (ob *Observer) Run(ctx context.Context) error {
+	ob.mu.Lock()
+	if ob.closed {
+		ob.mu.Unlock()
+		return nil
+	}
+	if ob.started {
+		ob.mu.Unlock()
+		return errors.New("observer is already running")
+	}
+	ob.started = true
+	ctx, ob.cancel = context.WithCancel(ctx)
+	o := ob.opts
+	if ob.alerts != nil {
+		o.alertChan = ob.alerts
+	}
+	if ob.logs != nil {
+		o.logChan = ob.logs
+	}
+	ob.mu.Unlock()
+
+	defer ob.finish()
+	return startObservers(ctx, ob.targets, o)
+}
+
+// finish closes the channels once Run is done
+func (ob *Observer) finish() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.cancel()
+	if ob.alerts != nil {
+		close(ob.alerts)
+	}
+	if ob.logs != nil {
+		close(ob.logs)
+	}
+	close(ob.done)
+}
+
+// Close stops the observer and waits for Run to return
+func (ob *Observer) Close() error {
+	ob.mu.Lock()
+	if ob.closed {
+		ob.mu.Unlock()
+		<-ob.done
+		return nil
+	}
+	ob.closed = true
+	if !ob.started {
+		// never run, there is nothing to wait for
+		ob.started = true
+		ob.cancel = func() {}
+		ob.mu.Unlock()
+		ob.finish()
+		return nil
+	}
+	cancel := ob.cancel
+	ob.mu.Unlock()
+
+	cancel()
+	<-ob.done
+	return nil
+}
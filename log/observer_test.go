@@ -0,0 +1,184 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+)
+
+func TestObserverTypedChannels(t *testing.T) {
+	srv := &fakeLogServer{perStream: 4}
+	addr := startFakeLogServer(t, srv)
+
+	ob, err := NewObserver(&k8s.Client{}, Options{
+		GRPC:      addr,
+		MsgPath:   "none",
+		LogPath:   "none",
+		LogFilter: "all",
+		Filter:    "Type == MatchedPolicy OR Operation == Process",
+		Limit:     4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts, logs := ob.Alerts(), ob.Logs()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ob.Run(context.Background())
+	}()
+
+	var nAlerts, nLogs int
+	for alerts != nil || logs != nil {
+		select {
+		case a, ok := <-alerts:
+			if !ok {
+				alerts = nil
+				continue
+			}
+			if a.PolicyName != "policy" {
+				t.Errorf("unexpected alert %v", a)
+			}
+			nAlerts++
+		case _, ok := <-logs:
+			if !ok {
+				logs = nil
+				continue
+			}
+			nLogs++
+		case <-time.After(10 * time.Second):
+			t.Fatal("observer did not stop")
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if nAlerts != 0 || nLogs != 4 {
+		t.Errorf("expected 0 alerts and 4 logs, got %d and %d", nAlerts, nLogs)
+	}
+
+	if err := ob.Run(context.Background()); err == nil {
+		t.Error("expected an error when running an observer twice")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer written to by several goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestObserverStderr(t *testing.T) {
+	srv := &fakeLogServer{perStream: 1}
+	addr := startFakeLogServer(t, srv)
+
+	var stderr lockedBuffer
+	ob, err := NewObserver(&k8s.Client{}, Options{
+		GRPC:      addr,
+		MsgPath:   "none",
+		LogPath:   "none",
+		LogFilter: "policy",
+		Limit:     1,
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := ob.Alerts()
+	go func() {
+		for range alerts {
+		}
+	}()
+	if err := ob.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "Started to watch alerts") {
+		t.Errorf("expected the messages of the observer on Stderr, got %q", stderr.String())
+	}
+}
+
+func TestObserverConcurrentClose(t *testing.T) {
+	srv := &fakeLogServer{perStream: 1}
+	addr := startFakeLogServer(t, srv)
+
+	// two observers in one process, closed while running
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		ob, err := NewObserver(&k8s.Client{}, Options{
+			GRPC:      addr,
+			MsgPath:   "none",
+			LogPath:   "none",
+			LogFilter: "system",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs := ob.Logs()
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := ob.Run(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			<-logs
+			_ = ob.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			<-logs
+			_ = ob.Close()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("observers did not close")
+	}
+}
+
+func TestObserverCloseBeforeRun(t *testing.T) {
+	ob, err := NewObserver(&k8s.Client{}, Options{MsgPath: "none", LogPath: "none", LogFilter: "policy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := ob.Alerts()
+	if err := ob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-alerts; ok {
+		t.Error("expected the alerts channel to be closed")
+	}
+	if err := ob.Run(context.Background()); err != nil {
+		t.Errorf("expected a closed observer not to run, got %v", err)
+	}
+
+	if _, err := NewObserver(&k8s.Client{}, Options{Filter: "Severity >"}); err == nil {
+		t.Error("expected an error for an invalid filter")
+	}
+}
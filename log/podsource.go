@@ -0,0 +1,310 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Sources of the telemetry, see Options.LogSource
+const (
+	SourceRelay = "relay" // the gRPC streams of kubearmor-relay, or of GRPC
+	SourcePods  = "pods"  // the stdout of the KubeArmor pods, see observePods
+)
+
+const (
+	// kubearmorPodLabels select the KubeArmor daemon pods
+	kubearmorPodLabels = "kubearmor-app=kubearmor"
+
+	// kubearmorContainer is the container of the pods writing the telemetry
+	kubearmorContainer = "kubearmor"
+
+	// podSourceResync is how often new or restarted KubeArmor pods are
+	// looked for
+	podSourceResync = 10 * time.Second
+
+	// podLogMaxLine bounds a line of the pod logs, alerts carry process
+	// trees and long command lines
+	podLogMaxLine = 1 << 20
+)
+
+// openPodLogs follows the logs of a container since the given time, each
+// line is prefixed with its RFC3339 time
+var openPodLogs = func(ctx context.Context, c kubernetes.Interface, pod *corev1.Pod, container string, since time.Time) (io.ReadCloser, error) {
+	sinceTime := metav1.NewTime(since)
+	return c.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  container,
+		Follow:     true,
+		SinceTime:  &sinceTime,
+		Timestamps: true,
+	}).Stream(ctx)
+}
+
+// observePods follows the stdout of every KubeArmor pod of a cluster instead
+// of the relay, for KubeArmor run with EnableStdOutAlerts and EnableStdOutLogs.
+// The JSON lines are parsed into alerts and logs and handled as the ones of
+// the relay. It returns nil once interrupted or --limit is reached.
+func observePods(c *k8s.Client, o Options) error {
+	if c == nil || c.K8sClientset == nil {
+		return errors.New("--from=pods needs a cluster to read the KubeArmor pods from")
+	}
+	if o.MsgPath != "none" {
+		fmt.Fprintln(o.stderr(), "Messages are only streamed by the relay, ignoring --msgPath with --from=pods")
+	}
+	if !o.watchAlerts() && !o.watchLogs() {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ps := &podSource{
+		client:    c.K8sClientset,
+		o:         o,
+		cancel:    cancel,
+		following: map[string]bool{},
+		since:     map[string]time.Time{},
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(podSourceResync)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		n, err := ps.sync(ctx, started)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil && first:
+			return err
+		case err != nil:
+			// pods already followed go on meanwhile
+			fmt.Fprintln(o.stderr(), err.Error())
+		case n == 0 && first:
+			return fmt.Errorf("no running KubeArmor pods found with the label %s", kubearmorPodLabels)
+		}
+		o.metrics.setConnected(o.clusterContext, n > 0)
+
+		select {
+		case <-ctx.Done():
+			ps.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// podSource follows the logs of the KubeArmor pods
+type podSource struct {
+	client kubernetes.Interface
+	o      Options
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	following map[string]bool      // containers followed, by pod UID
+	since     map[string]time.Time // of the last line read, by pod UID
+}
+
+// sync follows the running KubeArmor pods not followed yet, and forgets the
+// pods gone. It returns the number of running pods.
+func (ps *podSource) sync(ctx context.Context, started time.Time) (int, error) {
+	pods, err := ps.client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: kubearmorPodLabels})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the KubeArmor pods: %w", err)
+	}
+
+	listed := map[string]bool{}
+	running := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		listed[string(pod.UID)] = true
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		running++
+
+		uid := string(pod.UID)
+		ps.mu.Lock()
+		if ps.following[uid] {
+			ps.mu.Unlock()
+			continue
+		}
+		ps.following[uid] = true
+		since, ok := ps.since[uid]
+		if !ok {
+			since = started
+		}
+		ps.mu.Unlock()
+
+		ps.wg.Add(1)
+		go func() {
+			defer ps.wg.Done()
+			ps.follow(ctx, pod, since)
+		}()
+	}
+
+	ps.mu.Lock()
+	for uid := range ps.since {
+		if !listed[uid] && !ps.following[uid] {
+			delete(ps.since, uid)
+		}
+	}
+	ps.mu.Unlock()
+	return running, nil
+}
+
+// follow handles the lines of a pod after since until its logs end, the pod
+// is looked for again on the next sync. SinceTime is in seconds, so the lines
+// up to the last one read are sent again on resuming and are skipped by their
+// time.
+func (ps *podSource) follow(ctx context.Context, pod *corev1.Pod, since time.Time) {
+	uid := string(pod.UID)
+	defer func() {
+		ps.mu.Lock()
+		delete(ps.following, uid)
+		ps.mu.Unlock()
+	}()
+
+	container := kubearmorContainer
+	if !hasContainer(pod, container) && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	stream, err := openPodLogs(ctx, ps.client, pod, container, since)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(ps.o.stderr(), "Failed to follow the logs of %s/%s (%s)\n", pod.Namespace, pod.Name, err.Error())
+		}
+		return
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	fmt.Fprintf(ps.o.stderr(), "Started to follow %s/%s\n", pod.Namespace, pod.Name)
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), podLogMaxLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if stamp, rest, ok := bytes.Cut(line, []byte{' '}); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, string(stamp)); err == nil {
+				if !ts.After(since) {
+					continue
+				}
+				since, line = ts, rest
+				ps.mu.Lock()
+				ps.since[uid] = ts
+				ps.mu.Unlock()
+			}
+		}
+
+		if !ps.o.handleLine(line) {
+			ps.cancel()
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(ps.o.stderr(), "Failed to read the logs of %s/%s (%s)\n", pod.Namespace, pod.Name, err.Error())
+	}
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLine handles a line written by KubeArmor, to stdout or to its log
+// files, if it is an alert or a log. It returns false once stopped or --limit
+// is reached.
+func (o Options) handleLine(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		// the other logs of KubeArmor
+		return true
+	}
+	var kind struct {
+		Type       string
+		PolicyName string
+	}
+	if err := json.Unmarshal(line, &kind); err != nil {
+		return true
+	}
+
+	switch {
+	case kind.Type == "ContainerLog" || kind.Type == "HostLog":
+		if !o.watchLogs() {
+			return true
+		}
+		var res pb.Log
+		if err := json.Unmarshal(line, &res); err != nil {
+			return true
+		}
+		if o.treeOnlyLogs() {
+			handleTelemetry(line, "Log", o)
+			return true
+		}
+		o.metrics.received(o.clusterContext)
+		if !takeSlot(o.counters.get("Log"), o.Limit) {
+			return !o.limitReached()
+		}
+		if !o.deliverLog(&res) {
+			return false
+		}
+	case kind.PolicyName != "" || strings.HasPrefix(kind.Type, "Matched"):
+		if !o.watchAlerts() {
+			return true
+		}
+		var res pb.Alert
+		if err := json.Unmarshal(line, &res); err != nil {
+			return true
+		}
+		o.metrics.received(o.clusterContext)
+		if !takeSlot(o.counters.get("Alert"), o.Limit) {
+			return !o.limitReached()
+		}
+		if !o.deliverAlert(&res) {
+			return false
+		}
+	}
+	return !o.limitReached()
+}
+
+// limitReached reports whether --limit events of every type watched were
+// handled
+func (o Options) limitReached() bool {
+	if o.Limit == 0 {
+		return false
+	}
+	if o.watchAlerts() && o.counters.get("Alert").Load() < o.Limit {
+		return false
+	}
+	if o.watchLogs() && !o.treeOnlyLogs() && o.counters.get("Log").Load() < o.Limit {
+		return false
+	}
+	return true
+}
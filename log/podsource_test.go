@@ -0,0 +1,172 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func kubearmorPod(name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kubearmor", UID: types.UID(name),
+			Labels: map[string]string{"kubearmor-app": "kubearmor"}},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "init-helper"}, {Name: "kubearmor"}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+// stampLines prefixes the lines with their time as the pod logs do, a
+// millisecond apart from start
+func stampLines(logs string, start time.Time) string {
+	var b strings.Builder
+	for i, line := range strings.SplitAfter(logs, "\n") {
+		if line != "" {
+			b.WriteString(start.Add(time.Duration(i)*time.Millisecond).Format(time.RFC3339Nano) + " " + line)
+		}
+	}
+	return b.String()
+}
+
+func TestObservePods(t *testing.T) {
+	logs := map[string]string{
+		"kubearmor-a": `2024-01-01 00:00:00.000000	INFO	Started to protect a host and containers
+{"Timestamp":1,"NamespaceName":"prod","PodName":"web","Type":"MatchedPolicy","PolicyName":"block-shadow","Action":"Block"}
+{"Timestamp":2,"NamespaceName":"prod","PodName":"web","Type":"ContainerLog","Operation":"Process","Resource":"/bin/ls"}
+{"Timestamp":3,"NamespaceName":"dev","PodName":"api","Type":"MatchedPolicy","PolicyName":"audit-curl","Action":"Audit"}
+not json {
+`,
+		"kubearmor-b": `{"Timestamp":4,"HostName":"node-b","Type":"MatchedHostPolicy","PolicyName":"host-audit","Action":"Audit"}
+{"Timestamp":5,"HostName":"node-b","Type":"HostLog","Operation":"File","Resource":"/etc/hosts"}
+`,
+		"kubearmor-pending": `{"Type":"MatchedPolicy","PolicyName":"never"}`,
+	}
+
+	var mu sync.Mutex
+	containers := map[string]string{}
+	prev := openPodLogs
+	openPodLogs = func(ctx context.Context, c kubernetes.Interface, pod *corev1.Pod, container string, since time.Time) (io.ReadCloser, error) {
+		mu.Lock()
+		containers[pod.Name] = container
+		mu.Unlock()
+		if since.IsZero() || time.Since(since) > time.Minute {
+			t.Errorf("expected to follow %s from now, got %s", pod.Name, since)
+		}
+		return io.NopCloser(strings.NewReader(stampLines(logs[pod.Name], since.Add(time.Millisecond)))), nil
+	}
+	t.Cleanup(func() {
+		openPodLogs = prev
+	})
+
+	client := &k8s.Client{K8sClientset: fake.NewSimpleClientset(
+		kubearmorPod("kubearmor-a", corev1.PodRunning),
+		kubearmorPod("kubearmor-b", corev1.PodRunning),
+		kubearmorPod("kubearmor-pending", corev1.PodPending),
+	)}
+
+	events := make(chan EventInfo, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- startObservers(context.Background(), []clusterTarget{{client: client}}, Options{
+			LogSource: SourcePods,
+			MsgPath:   "none",
+			LogFilter: "all",
+			Filter:    "Action!=Block",
+			Limit:     2,
+			EventChan: events,
+		})
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the pod source did not stop at the limit")
+	}
+
+	counts := map[string]int{}
+	for len(events) > 0 {
+		ev := <-events
+		var res map[string]interface{}
+		if err := json.Unmarshal(ev.Data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res["Action"] == "Block" || res["PolicyName"] == "never" {
+			t.Errorf("unexpected event %s", ev.Data)
+		}
+		counts[ev.Type]++
+	}
+	// the blocked alert takes a slot of the limit as with the relay
+	if counts["Alert"] > 1 || counts["Log"] != 2 {
+		t.Errorf("expected at most 1 alert and 2 logs, got %v", counts)
+	}
+	if containers["kubearmor-a"] != "kubearmor" || containers["kubearmor-pending"] != "" {
+		t.Errorf("unexpected containers followed %v", containers)
+	}
+
+	err := startObservers(context.Background(), []clusterTarget{{client: &k8s.Client{K8sClientset: fake.NewSimpleClientset()}}},
+		Options{LogSource: SourcePods, MsgPath: "none", LogFilter: "policy"})
+	if err == nil || !strings.Contains(err.Error(), "no running KubeArmor pods") {
+		t.Errorf("expected an error without KubeArmor pods, got %v", err)
+	}
+}
+
+func TestPodSourceResume(t *testing.T) {
+	last := time.Now().Add(-time.Minute)
+	prev := openPodLogs
+	openPodLogs = func(_ context.Context, _ kubernetes.Interface, _ *corev1.Pod, _ string, since time.Time) (io.ReadCloser, error) {
+		// SinceTime is truncated to seconds
+		return io.NopCloser(strings.NewReader(stampLines(`{"Type":"MatchedPolicy","PolicyName":"seen"}
+{"Type":"MatchedPolicy","PolicyName":"seen"}
+{"Type":"MatchedPolicy","PolicyName":"new"}
+`, since.Truncate(time.Second)))), nil
+	}
+	t.Cleanup(func() {
+		openPodLogs = prev
+	})
+
+	events := make(chan EventInfo, 10)
+	o := Options{LogPath: "none", Sinks: []string{"file:" + filepath.Join(t.TempDir(), "alerts.log")}, LogFilter: "policy", EventChan: events}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := kubearmorPod("kubearmor-a", corev1.PodRunning)
+	ps := &podSource{
+		client:    fake.NewSimpleClientset(pod),
+		o:         o,
+		cancel:    func() {},
+		following: map[string]bool{},
+		since: map[string]time.Time{
+			"kubearmor-a":    last.Truncate(time.Second).Add(time.Millisecond),
+			"kubearmor-gone": last,
+		},
+	}
+	if _, err := ps.sync(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	ps.wg.Wait()
+	o.release()
+
+	if len(events) != 1 {
+		t.Fatalf("expected the new alert only, got %d events", len(events))
+	}
+	if ev := <-events; !strings.Contains(string(ev.Data), `"new"`) {
+		t.Errorf("unexpected event %s", ev.Data)
+	}
+	if _, ok := ps.since["kubearmor-gone"]; ok {
+		t.Error("expected the pod gone to be forgotten")
+	}
+}
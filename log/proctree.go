@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+)
+
+const (
+	// maxTreeProcesses bounds the processes remembered per container
+	maxTreeProcesses = 4096
+
+	// maxTreeDepth bounds the exec chains, guarding against PID reuse loops
+	maxTreeDepth = 64
+
+	// treeContainerTTL is how long the processes of a container without
+	// events are remembered, the containers of deleted pods go after it
+	treeContainerTTL = 30 * time.Minute
+)
+
+// ProcessTree is an in-memory process table per container, fed by the
+// process events of KubeArmor, used to reconstruct the exec chain of alerts
+type ProcessTree struct {
+	mu         sync.Mutex
+	containers map[string]*treeContainer
+	swept      time.Time // last eviction of the idle containers
+}
+
+type treeContainer struct {
+	name      string // namespace/pod/container, or the host name
+	processes map[int64]*treeProcess
+	seen      time.Time
+}
+
+type treeProcess struct {
+	pid      int64
+	hostPID  int64
+	hostPPID int64
+	name     string
+	parent   string
+	seen     time.Time
+}
+
+// NewProcessTree Function
+func NewProcessTree() *ProcessTree {
+	return &ProcessTree{containers: map[string]*treeContainer{}}
+}
+
+// containerKey identifies the container of an event, host processes are
+// keyed by the host name
+func containerKey(res map[string]interface{}) (string, string) {
+	if id := fieldString(res, "ContainerID"); id != "" {
+		name := strings.Join([]string{fieldString(res, "NamespaceName"), fieldString(res, "PodName"),
+			fieldString(res, "ContainerName")}, "/")
+		return id, name
+	}
+	host := fieldString(res, "HostName")
+	return "host/" + host, host
+}
+
+// Add records the process of an event
+func (pt *ProcessTree) Add(res map[string]interface{}) {
+	hostPID := int64(fieldNumber(res, "HostPID"))
+	if hostPID == 0 {
+		return
+	}
+	name := fieldString(res, "ProcessName")
+	parent := fieldString(res, "ParentProcessName")
+	if fieldString(res, "Operation") == "Process" {
+		// the Source of an exec is the parent, the Resource the new command
+		if name == "" {
+			name = command(fieldString(res, "Resource"))
+		}
+		if parent == "" {
+			parent = fieldString(res, "Source")
+		}
+	} else if name == "" {
+		name = command(fieldString(res, "Source"))
+	}
+
+	key, cname := containerKey(res)
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(pt.swept) > treeContainerTTL/10 {
+		pt.evictIdle(now)
+	}
+	c, ok := pt.containers[key]
+	if !ok {
+		c = &treeContainer{name: cname, processes: map[int64]*treeProcess{}}
+		pt.containers[key] = c
+	}
+	c.seen = now
+	p, ok := c.processes[hostPID]
+	if !ok {
+		if len(c.processes) >= maxTreeProcesses {
+			c.evictOldest()
+		}
+		p = &treeProcess{hostPID: hostPID}
+		c.processes[hostPID] = p
+	}
+	p.pid = int64(fieldNumber(res, "PID"))
+	p.seen = now
+	if ppid := int64(fieldNumber(res, "HostPPID")); ppid != 0 {
+		p.hostPPID = ppid
+	}
+	if name != "" {
+		p.name = name
+	}
+	if parent != "" {
+		p.parent = parent
+	}
+}
+
+// evictIdle forgets the containers without events for treeContainerTTL
+func (pt *ProcessTree) evictIdle(now time.Time) {
+	for key, c := range pt.containers {
+		if now.Sub(c.seen) > treeContainerTTL {
+			delete(pt.containers, key)
+		}
+	}
+	pt.swept = now
+}
+
+func (c *treeContainer) evictOldest() {
+	var oldest *treeProcess
+	for _, p := range c.processes {
+		if oldest == nil || p.seen.Before(oldest.seen) {
+			oldest = p
+		}
+	}
+	if oldest != nil {
+		delete(c.processes, oldest.hostPID)
+	}
+}
+
+// Chain returns the exec chain of the process of an event, e.g.
+// "containerd-shim → sh → curl"
+func (pt *ProcessTree) Chain(res map[string]interface{}) string {
+	hostPID := int64(fieldNumber(res, "HostPID"))
+	key, _ := containerKey(res)
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	var names []string
+	if c, ok := pt.containers[key]; ok {
+		seen := map[int64]bool{}
+		parent := ""
+		for pid := hostPID; pid != 0 && !seen[pid] && len(names) < maxTreeDepth; {
+			p, ok := c.processes[pid]
+			if !ok {
+				break
+			}
+			seen[pid] = true
+			names = append(names, processName(p.name))
+			parent = p.parent
+			pid = p.hostPPID
+		}
+		if parent != "" {
+			// the oldest known ancestor was not seen starting
+			names = append(names, processName(parent))
+		}
+	}
+	if len(names) == 0 {
+		for _, n := range []string{fieldString(res, "ProcessName"), fieldString(res, "ParentProcessName")} {
+			if n != "" {
+				names = append(names, processName(n))
+			}
+		}
+	}
+
+	// ancestors first
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, " → ")
+}
+
+// toFieldMap converts an alert or log to the fields the filters work on
+func toFieldMap(v interface{}) map[string]interface{} {
+	var res map[string]interface{}
+	arr, _ := json.Marshal(v)
+	_ = json.Unmarshal(arr, &res)
+	return res
+}
+
+// command returns the executable of a command line
+func command(cmdline string) string {
+	if fields := strings.Fields(cmdline); len(fields) != 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// processName shortens an executable path to its name
+func processName(path string) string {
+	return filepath.Base(strings.TrimSpace(path))
+}
+
+// Render writes the process tree of every container
+func (pt *ProcessTree) Render(w io.Writer) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	keys := make([]string, 0, len(pt.containers))
+	for k := range pt.containers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return pt.containers[keys[i]].name < pt.containers[keys[j]].name
+	})
+
+	for _, k := range keys {
+		c := pt.containers[k]
+		fmt.Fprintf(w, "%s (%s)\n", c.name, strings.TrimPrefix(k, "host/"))
+
+		children := map[int64][]*treeProcess{}
+		var roots []*treeProcess
+		for _, p := range c.processes {
+			if _, ok := c.processes[p.hostPPID]; ok && p.hostPPID != p.hostPID {
+				children[p.hostPPID] = append(children[p.hostPPID], p)
+			} else {
+				roots = append(roots, p)
+			}
+		}
+		var walk func(ps []*treeProcess, indent string, depth int)
+		walk = func(ps []*treeProcess, indent string, depth int) {
+			sort.Slice(ps, func(i, j int) bool { return ps[i].hostPID < ps[j].hostPID })
+			for i, p := range ps {
+				branch, next := "├─ ", "│  "
+				if i == len(ps)-1 {
+					branch, next = "└─ ", "   "
+				}
+				fmt.Fprintf(w, "%s%s%s (%d)\n", indent, branch, processName(p.name), p.pid)
+				if depth < maxTreeDepth {
+					walk(children[p.hostPID], indent+next, depth+1)
+				}
+			}
+		}
+		for _, r := range roots {
+			if r.parent != "" {
+				// show the parent the root was started by
+				fmt.Fprintf(w, "└─ %s\n", processName(r.parent))
+				walk([]*treeProcess{r}, "   ", 0)
+			} else {
+				walk([]*treeProcess{r}, "", 0)
+			}
+		}
+	}
+}
+
+// StartProcessTreeView renders the live process tree of the containers
+// matching the options every interval, until interrupted
+func StartProcessTreeView(c *k8s.Client, o Options, interval time.Duration) error {
+	o.LogFilter = "system"
+	o.LogPath = "none"
+	o.MsgPath = "none"
+	o.Limit = 0
+	ob, err := NewObserver(c, o)
+	if err != nil {
+		return err
+	}
+	logs := ob.Logs()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), osSignals...)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ob.Run(ctx)
+	}()
+
+	// redraw in place on a terminal
+	redraw := false
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		redraw = true
+	}
+
+	tree := NewProcessTree()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case l, ok := <-logs:
+			if !ok {
+				return <-errCh
+			}
+			tree.Add(toFieldMap(l))
+		case <-ticker.C:
+			if redraw {
+				fmt.Print("\033[H\033[2J")
+			}
+			fmt.Printf("== Process tree / %s ==\n", time.Now().Format(time.DateTime))
+			tree.Render(os.Stdout)
+		}
+	}
+}
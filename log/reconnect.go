@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	"github.com/kubearmor/kubearmor-client/utils"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRetryBackoff caps the delay between reconnect attempts
+const maxRetryBackoff = time.Minute
+
+// streamCounters counts the events received per stream towards --limit. It
+// outlives the Feeder so that the limit holds across reconnects.
+type streamCounters struct {
+	alerts atomic.Uint32
+	logs   atomic.Uint32
+}
+
+func (c *streamCounters) get(t string) *atomic.Uint32 {
+	if c == nil {
+		return new(atomic.Uint32)
+	}
+	if t == "Alert" {
+		return &c.alerts
+	}
+	return &c.logs
+}
+
+// takeSlot counts an event towards the limit, it returns false if the limit
+// is already reached
+func takeSlot(count *atomic.Uint32, limit uint32) bool {
+	for {
+		n := count.Load()
+		if limit > 0 && n >= limit {
+			return false
+		}
+		if count.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// reconnectBackoff yields exponentially growing delays with jitter
+type reconnectBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func (b *reconnectBackoff) next() time.Duration {
+	d := b.initial
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+
+	// equal jitter: half of the delay is fixed, the other half random
+	half := d / 2
+	// #nosec
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// connect resolves the gRPC endpoint, port-forwarding to the relay, or to
+// the daemon with Direct, if needed, creates the log client and checks the liveness of the server. The
+// returned function releases the port forward.
+func connect(c *k8s.Client, o *Options) (*Feeder, func(), error) {
+	gRPC := ""
+	targetSvc := "kubearmor-relay"
+	release := func() {}
+
+	if o.daemon != nil {
+		addr, stop, err := forwardToPod(c, o.daemon)
+		if err != nil {
+			return nil, release, err
+		}
+		gRPC = addr
+		release = stop
+	} else if o.GRPC != "" {
+		gRPC = o.GRPC
+	} else if val, ok := os.LookupEnv("KUBEARMOR_SERVICE"); ok {
+		gRPC = val
+	} else {
+		pf, err := utils.InitiatePortForward(c, port, port, matchLabels, targetSvc)
+		if err != nil {
+			return nil, release, err
+		}
+		gRPC = "localhost:" + strconv.FormatInt(pf.LocalPort, 10)
+		release = pf.Stop
+	}
+
+	// there is no cluster with --gRPC and certificates from files
+	var clientset kubernetes.Interface
+	if c != nil {
+		clientset = c.K8sClientset
+	}
+
+	// create client
+	logClient, err := NewClient(gRPC, *o, clientset)
+	if err != nil {
+		if !o.Secure && !isDialingError(err) {
+			// retry connecting to the server on secured channel
+			fmt.Fprintf(o.stderr(), "Failed to connect on insecure channel\n(%s)\n", err)
+			fmt.Fprint(o.stderr(), "Trying to reconnect using secured channel...\n")
+			o.Secure = true
+			logClient, err = NewClient(gRPC, *o, clientset)
+			if err != nil {
+				release()
+				return nil, release, fmt.Errorf("unable to create log client, error=%s", err)
+			}
+		} else {
+			release()
+			return nil, release, fmt.Errorf("unable to create log client, error=%s", err)
+		}
+	}
+
+	fmt.Fprintf(o.stderr(), "Created a gRPC client (%s)\n", gRPC)
+
+	// do healthcheck
+	if ok := logClient.DoHealthCheck(); !ok {
+		_ = logClient.DestroyClient()
+		release()
+		return nil, release, errors.New("failed to check the liveness of the gRPC server")
+	}
+	fmt.Fprintln(o.stderr(), "Checked the liveness of the gRPC server")
+
+	return logClient, release, nil
+}
+
+// reconnect re-establishes the connection after the streams dropped,
+// backing off between attempts. It returns a nil Feeder if interrupted.
+func reconnect(c *k8s.Client, o *Options, cause error) (*Feeder, func(), error) {
+	backoff := reconnectBackoff{initial: o.RetryBackoff, max: maxRetryBackoff}
+	if backoff.initial <= 0 {
+		backoff.initial = time.Second
+	}
+
+	err := cause
+	for attempt := 1; o.MaxRetries < 0 || attempt <= o.MaxRetries; attempt++ {
+		delay := backoff.next()
+		budget := "unlimited"
+		if o.MaxRetries >= 0 {
+			budget = strconv.Itoa(o.MaxRetries)
+		}
+		fmt.Fprintf(o.stderr(), "Lost connection to the gRPC server (%s), reconnecting in %s (attempt %d/%s)\n",
+			err.Error(), delay.Round(time.Millisecond), attempt, budget)
+
+		select {
+		case <-o.stop:
+			return nil, func() {}, nil
+		case <-time.After(delay):
+		}
+
+		var logClient *Feeder
+		var release func()
+		logClient, release, err = connect(c, o)
+		if err == nil {
+			fmt.Fprintln(o.stderr(), "Reconnected to the gRPC server")
+			return logClient, release, nil
+		}
+	}
+	if o.MaxRetries > 0 {
+		return nil, func() {}, fmt.Errorf("giving up after %d reconnect attempts: %w", o.MaxRetries, err)
+	}
+	return nil, func() {}, err
+}
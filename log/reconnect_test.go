@@ -0,0 +1,193 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	"google.golang.org/grpc"
+)
+
+// fakeLogServer serves a fixed number of alerts and logs per stream and then
+// drops the stream, emulating a relay restart.
+type fakeLogServer struct {
+	pb.UnimplementedLogServiceServer
+
+	perStream   int
+	resource    string // Resource of the alerts and logs
+	failAfter   int32  // health checks after the first failAfter ones fail
+	healthCalls atomic.Int32
+	streams     atomic.Int32
+}
+
+func (s *fakeLogServer) HealthCheck(_ context.Context, n *pb.NonceMessage) (*pb.ReplyMessage, error) {
+	if n := s.healthCalls.Add(1); s.failAfter > 0 && n > s.failAfter {
+		return nil, errors.New("unhealthy")
+	}
+	return &pb.ReplyMessage{Retval: n.Nonce}, nil
+}
+
+func (s *fakeLogServer) WatchAlerts(_ *pb.RequestMessage, stream grpc.ServerStreamingServer[pb.Alert]) error {
+	n := s.streams.Add(1)
+	for i := 0; i < s.perStream; i++ {
+		if err := stream.Send(&pb.Alert{PolicyName: "policy", HostPID: n*100 + int32(i), Resource: s.resource}); err != nil {
+			return err
+		}
+	}
+	return errors.New("relay restarting")
+}
+
+func (s *fakeLogServer) WatchLogs(_ *pb.RequestMessage, stream grpc.ServerStreamingServer[pb.Log]) error {
+	for i := 0; i < s.perStream; i++ {
+		if err := stream.Send(&pb.Log{Operation: "Process", Timestamp: time.Now().Unix(), Resource: s.resource}); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func startFakeLogServer(t *testing.T, srv *fakeLogServer) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	pb.RegisterLogServiceServer(gs, srv)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	t.Cleanup(gs.Stop)
+	return lis.Addr().String()
+}
+
+// runObserver runs StartObserver and fails the test if it does not return
+func runObserver(t *testing.T, o Options) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- StartObserver(&k8s.Client{}, o)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("observer did not stop")
+	}
+	return nil
+}
+
+func TestReconnectKeepsLimit(t *testing.T) {
+	srv := &fakeLogServer{perStream: 3}
+	addr := startFakeLogServer(t, srv)
+
+	events := make(chan EventInfo, 100)
+	err := runObserver(t, Options{
+		GRPC:         addr,
+		MsgPath:      "none",
+		LogFilter:    "policy",
+		Limit:        7,
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+		EventChan:    events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 7 {
+		t.Errorf("expected 7 alerts across reconnects, got %d", len(events))
+	}
+	if n := srv.streams.Load(); n != 3 {
+		t.Errorf("expected 3 alert streams, got %d", n)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	// fail every health check after the first connection
+	srv := &fakeLogServer{perStream: 1, failAfter: 1}
+	addr := startFakeLogServer(t, srv)
+
+	events := make(chan EventInfo, 100)
+
+	err := runObserver(t, Options{
+		GRPC:         addr,
+		MsgPath:      "none",
+		LogFilter:    "policy",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		EventChan:    events,
+	})
+	if err == nil {
+		t.Fatal("expected an error once the retry budget is exhausted")
+	}
+	if n := srv.healthCalls.Load(); n != 3 {
+		t.Errorf("expected 1 health check and 2 retries, got %d", n)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	b := reconnectBackoff{initial: 100 * time.Millisecond, max: time.Second}
+	for attempt, ceil := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		d := b.next()
+		ceil *= time.Millisecond
+		if d < ceil/2 || d > ceil {
+			t.Errorf("attempt %d: delay %s not within [%s, %s]", attempt, d, ceil/2, ceil)
+		}
+	}
+}
+
+func TestObserveDuration(t *testing.T) {
+	srv := &fakeLogServer{perStream: 2}
+	addr := startFakeLogServer(t, srv)
+
+	events := make(chan EventInfo, 100)
+	start := time.Now()
+	err := runObserver(t, Options{
+		GRPC:      addr,
+		MsgPath:   "none",
+		LogFilter: "system",
+		Duration:  200 * time.Millisecond,
+		EventChan: events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("stopped after %s, before --duration", elapsed)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected 2 logs, got %d", len(events))
+	}
+}
+
+func TestObserveUntilDuration(t *testing.T) {
+	srv := &fakeLogServer{perStream: 2}
+	addr := startFakeLogServer(t, srv)
+
+	until, err := ParseDeadline("200ms", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan EventInfo, 100)
+	start := time.Now()
+	err = runObserver(t, Options{
+		GRPC:      addr,
+		MsgPath:   "none",
+		LogFilter: "system",
+		Until:     until,
+		EventChan: events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("stopped after %s, before --until", elapsed)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected 2 logs, got %d", len(events))
+	}
+}
@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"sigs.k8s.io/yaml"
+)
+
+// RedactModes are the ways secrets are replaced
+var RedactModes = []string{"mask", "hash"}
+
+// redactFields are the fields of alerts and logs searched for secrets, they
+// carry command lines and paths
+var redactFields = []string{"Resource", "Data", "Source", "ProcessName", "ParentProcessName", "ProcessTree"}
+
+// redactMask replaces secrets in the mask mode
+const redactMask = "[REDACTED]"
+
+// RedactRule is a detector of secrets. The whole match is replaced, or only
+// the group named "secret" if the pattern has one, e.g. to keep the name of
+// a password= argument.
+type RedactRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+
+	re     *regexp.Regexp
+	secret int // index of the secret group, 0 for the whole match
+}
+
+// builtinRedactRules are the detectors enabled by --redact
+var builtinRedactRules = []RedactRule{
+	{Name: "aws-access-key", Pattern: `\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b`},
+	{Name: "aws-secret-key", Pattern: `(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})(?:[^A-Za-z0-9/+=]|$)`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`},
+	{Name: "bearer-token", Pattern: `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/=-]+)`},
+	{Name: "password", Pattern: `(?i)\b[\w.-]*(?:password|passwd|pwd|secret|token|api[_-]?key)=(?P<secret>[^\s&'";]+)`},
+	{Name: "password-flag", Pattern: `(?i)(?:^|\s)--?(?:password|passwd|secret|token|api[_-]?key)\s+(?P<secret>[^\s-][^\s]*)`},
+	{Name: "url-credentials", Pattern: `(?i)\b[a-z][a-z0-9+.-]*://[^\s:/@]*:(?P<secret>[^\s@/]+)@`},
+}
+
+// redactConfig is the file given with --redact-rules
+type redactConfig struct {
+	Rules []RedactRule `json:"rules"`
+}
+
+// redactor replaces the secrets in the fields of alerts and logs
+type redactor struct {
+	rules []RedactRule
+	hash  bool
+}
+
+// newRedactor compiles the built-in rules with Redact and the rules of the
+// RedactRules file, it returns nil if there are none
+func newRedactor(o Options) (*redactor, error) {
+	r := &redactor{}
+	switch o.RedactMode {
+	case "", "mask":
+	case "hash":
+		r.hash = true
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q, use mask or hash", o.RedactMode)
+	}
+
+	rules := []RedactRule{}
+	if o.Redact {
+		rules = append(rules, builtinRedactRules...)
+	}
+	if o.RedactRules != "" {
+		custom, err := loadRedactRules(o.RedactRules)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, custom...)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func loadRedactRules(path string) ([]RedactRule, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the redaction rules: %w", err)
+	}
+	var cfg redactConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid redaction rules in %s: %w", path, err)
+	}
+	for i, rule := range cfg.Rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("redaction rule %d in %s has no pattern", i+1, path)
+		}
+		if rule.Name == "" {
+			cfg.Rules[i].Name = fmt.Sprintf("rule-%d", i+1)
+		}
+	}
+	return cfg.Rules, nil
+}
+
+func (rule *RedactRule) compile() error {
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid redaction rule %s: %w", rule.Name, err)
+	}
+	rule.re = re
+	rule.secret = re.SubexpIndex("secret")
+	if rule.secret < 0 {
+		rule.secret = 0
+	}
+	return nil
+}
+
+// replacement returns what a secret is replaced with
+func (r *redactor) replacement(secret string) string {
+	if !r.hash {
+		return redactMask
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "[sha256:" + hex.EncodeToString(sum[:6]) + "]"
+}
+
+// isReplacement returns whether s was already redacted, so that redacting
+// an event twice keeps its hashes
+func isReplacement(s string) bool {
+	return s == redactMask || (strings.HasPrefix(s, "[sha256:") && strings.HasSuffix(s, "]"))
+}
+
+// redactString replaces the secrets in s
+func (r *redactor) redactString(s string) string {
+	for _, rule := range r.rules {
+		matches := rule.re.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+		var sb strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[2*rule.secret], m[2*rule.secret+1]
+			if start < 0 || start < last || isReplacement(s[start:end]) {
+				continue
+			}
+			sb.WriteString(s[last:start])
+			sb.WriteString(r.replacement(s[start:end]))
+			last = end
+		}
+		sb.WriteString(s[last:])
+		s = sb.String()
+	}
+	return s
+}
+
+// redactAlert replaces the secrets in the fields of an alert as received,
+// before it is recorded or handed to the Observer
+func (r *redactor) redactAlert(a *pb.Alert) {
+	if r == nil {
+		return
+	}
+	for _, v := range []*string{&a.Resource, &a.Data, &a.Source, &a.ProcessName, &a.ParentProcessName} {
+		*v = r.redactString(*v)
+	}
+}
+
+// redactLog is redactAlert for logs
+func (r *redactor) redactLog(l *pb.Log) {
+	if r == nil {
+		return
+	}
+	for _, v := range []*string{&l.Resource, &l.Data, &l.Source, &l.ProcessName, &l.ParentProcessName} {
+		*v = r.redactString(*v)
+	}
+}
+
+// redactMessage is redactAlert for the messages of KubeArmor
+func (r *redactor) redactMessage(m *pb.Message) {
+	if r == nil {
+		return
+	}
+	m.Message = r.redactString(m.Message)
+}
+
+// redact replaces the secrets in the fields of an event, in res and in its
+// JSON arr. The fields the client adds, e.g. ProcessTree, are only redacted
+// here.
+func (r *redactor) redact(arr []byte, res map[string]interface{}) []byte {
+	if r == nil {
+		return arr
+	}
+	for _, field := range redactFields {
+		v, ok := res[field].(string)
+		if !ok || v == "" {
+			continue
+		}
+		redacted := r.redactString(v)
+		if redacted == v {
+			continue
+		}
+		res[field] = redacted
+		arr = replaceField(arr, res, field, v, redacted)
+	}
+	return arr
+}
+
+// replaceField replaces the string value of a field in the JSON arr, keeping
+// the order of the other fields, or marshals res again if the value is not
+// found as encoded
+func replaceField(arr []byte, res map[string]interface{}, key, old, value string) []byte {
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return arr
+	}
+	newJSON, err := json.Marshal(value)
+	if err != nil {
+		return arr
+	}
+	from := []byte(`"` + key + `":` + string(oldJSON))
+	if bytes.Count(arr, from) == 1 {
+		return bytes.Replace(arr, from, []byte(`"`+key+`":`+string(newJSON)), 1)
+	}
+	if out, err := json.Marshal(res); err == nil {
+		return out
+	}
+	return arr
+}
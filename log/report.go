@@ -0,0 +1,519 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	_ "embed" // need for embedding
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Kinds of tag reports, see NewTagReport
+const (
+	ReportMitre      = "mitre"      // MITRE ATT&CK tactics and techniques
+	ReportCompliance = "compliance" // controls of the compliance frameworks, MITRE included
+)
+
+// ReportKinds are the accepted kinds of tag reports
+var ReportKinds = []string{ReportMitre, ReportCompliance}
+
+// ReportFormats are the formats a tag report is rendered in
+var ReportFormats = []string{"text", "markdown", "html"}
+
+// reportWorkloads is the number of workloads listed per row, the most hit
+// first
+const reportWorkloads = 5
+
+//go:embed html/report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("reporttmpl").Parse(reportHTML))
+
+// mitreTag matches the MITRE tags of the policy templates, e.g.
+// MITRE_T1082_system_information_discovery, MITRE_TA0007_discovery or T1036.005
+var mitreTag = regexp.MustCompile(`(?i)^(?:mitre[_-]?)?(ta\d{4}|t\d{4}(?:\.\d{3})?)(?:[_-](.+))?$`)
+
+// mitreTactics are the tactics of the ATT&CK enterprise matrix, in its order
+var mitreTactics = []struct{ id, name string }{
+	{"TA0043", "Reconnaissance"},
+	{"TA0042", "Resource Development"},
+	{"TA0001", "Initial Access"},
+	{"TA0002", "Execution"},
+	{"TA0003", "Persistence"},
+	{"TA0004", "Privilege Escalation"},
+	{"TA0005", "Defense Evasion"},
+	{"TA0006", "Credential Access"},
+	{"TA0007", "Discovery"},
+	{"TA0008", "Lateral Movement"},
+	{"TA0009", "Collection"},
+	{"TA0011", "Command and Control"},
+	{"TA0010", "Exfiltration"},
+	{"TA0040", "Impact"},
+}
+
+// complianceTag matches the tags of the compliance frameworks, e.g.
+// NIST_800-53_SI-4, PCI_DSS or CIS
+var complianceTag = regexp.MustCompile(`(?i)^(mitre|nist|pci[_-]?dss|pci|cis|stig|hipaa|soc2|iso|gdpr|fedramp)(?:[_-](.*))?$`)
+
+// complianceFrameworks name the frameworks of complianceTag
+var complianceFrameworks = map[string]string{
+	"PCI":     "PCI-DSS",
+	"PCIDSS":  "PCI-DSS",
+	"PCI_DSS": "PCI-DSS",
+	"PCI-DSS": "PCI-DSS",
+	"FEDRAMP": "FedRAMP",
+}
+
+// unmappedGroup collects the techniques of alerts without a tactic tag
+const unmappedGroup = "Unmapped"
+
+// TagReport summarises the alerts by the MITRE, NIST, PCI and other
+// identifiers the policies tag them with: the hits and the affected workloads
+// of every technique per tactic, or of every control per framework
+type TagReport struct {
+	kind string
+
+	mu     sync.Mutex
+	first  time.Time
+	last   time.Time
+	alerts int // alerts seen
+	tagged int // alerts with tags of the kind
+	rows   map[string]*reportRow
+}
+
+type reportRow struct {
+	group     string
+	id        string
+	name      string
+	hits      int
+	workloads map[string]int
+	policies  map[string]bool
+}
+
+// NewTagReport starts an empty report of a kind of ReportKinds
+func NewTagReport(kind string) (*TagReport, error) {
+	if kind != ReportMitre && kind != ReportCompliance {
+		return nil, fmt.Errorf("unknown report %q, use one of %v", kind, ReportKinds)
+	}
+	return &TagReport{kind: kind, rows: map[string]*reportRow{}}, nil
+}
+
+// ValidReportFormat checks a report format, the empty one is text
+func ValidReportFormat(format string) error {
+	for _, f := range append([]string{""}, ReportFormats...) {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown report format %q, use one of %v", format, ReportFormats)
+}
+
+// Add counts an event that passed the filters, only alerts carry tags
+func (r *TagReport) Add(t string, res map[string]interface{}) {
+	if r == nil || t != "Alert" {
+		return
+	}
+	cells := r.cells(reportTags(res))
+	workload := reportWorkload(res)
+	policy := fieldString(res, "PolicyName")
+	ts := eventTime(res)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts++
+	if r.first.IsZero() || ts.Before(r.first) {
+		r.first = ts
+	}
+	if ts.After(r.last) {
+		r.last = ts
+	}
+	if len(cells) == 0 {
+		return
+	}
+	r.tagged++
+	for _, c := range cells {
+		key := c.group + "\x00" + c.id
+		row, ok := r.rows[key]
+		if !ok {
+			row = &reportRow{group: c.group, id: c.id, name: c.name, workloads: map[string]int{}, policies: map[string]bool{}}
+			r.rows[key] = row
+		}
+		if row.name == "" {
+			row.name = c.name
+		}
+		row.hits++
+		row.workloads[workload]++
+		if policy != "" {
+			row.policies[policy] = true
+		}
+	}
+}
+
+// AddEvents counts stored events, e.g. the ones of QueryEvents
+func (r *TagReport) AddEvents(events []StoredEvent) {
+	for _, ev := range events {
+		r.Add(ev.Type, ev.res)
+	}
+}
+
+// reportTags returns the tags of an alert, from the alert itself and the
+// matched policy with --enrich
+func reportTags(res map[string]interface{}) []string {
+	var tags []string
+	seen := map[string]bool{}
+	add := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, field := range []string{"Tags", "PolicyTags"} {
+		for _, tag := range strings.Split(fieldString(res, field), ",") {
+			add(tag)
+		}
+	}
+	if atags, ok := res["ATags"].([]interface{}); ok {
+		for _, tag := range atags {
+			add(valueString(tag))
+		}
+	}
+	return tags
+}
+
+// reportWorkload names the workload of an alert, its Workload with --enrich
+func reportWorkload(res map[string]interface{}) string {
+	ns, pod := fieldString(res, "NamespaceName"), fieldString(res, "PodName")
+	if owner := fieldString(res, "Workload"); owner != "" {
+		return ns + "/" + owner
+	}
+	if pod != "" {
+		return ns + "/" + pod
+	}
+	return "host/" + fieldString(res, "HostName")
+}
+
+type reportCell struct{ group, id, name string }
+
+// cells returns the rows of the report an alert with the given tags counts
+// towards, once each
+func (r *TagReport) cells(tags []string) []reportCell {
+	var cells []reportCell
+	seen := map[string]bool{}
+	add := func(c reportCell) {
+		if key := c.group + "\x00" + c.id; !seen[key] {
+			seen[key] = true
+			cells = append(cells, c)
+		}
+	}
+
+	if r.kind == ReportMitre {
+		var tactics []reportCell
+		var techniques []reportCell
+		marked := false
+		for _, tag := range tags {
+			m := mitreTag.FindStringSubmatch(tag)
+			if m == nil {
+				marked = marked || strings.EqualFold(tag, "MITRE")
+				continue
+			}
+			c := reportCell{id: strings.ToUpper(m[1]), name: strings.ReplaceAll(m[2], "_", " ")}
+			if strings.HasPrefix(c.id, "TA") {
+				tactics = append(tactics, c)
+			} else {
+				techniques = append(techniques, c)
+			}
+		}
+		if len(tactics) == 0 && (len(techniques) != 0 || marked) {
+			tactics = append(tactics, reportCell{id: unmappedGroup})
+		}
+		if len(techniques) == 0 && len(tactics) != 0 {
+			techniques = append(techniques, reportCell{id: "-"})
+		}
+		for _, tactic := range tactics {
+			group := tactic.id
+			if name := mitreTacticName(tactic.id, tactic.name); name != "" {
+				group += " " + name
+			}
+			for _, technique := range techniques {
+				add(reportCell{group: group, id: technique.id, name: technique.name})
+			}
+		}
+		return cells
+	}
+
+	// a bare framework tag only counts if no control of it is tagged
+	var bare []string
+	controls := map[string]bool{}
+	for _, tag := range tags {
+		framework, control := complianceControl(tag)
+		switch {
+		case framework == "":
+		case control == "":
+			bare = append(bare, framework)
+		default:
+			controls[framework] = true
+			add(reportCell{group: framework, id: control})
+		}
+	}
+	for _, framework := range bare {
+		if !controls[framework] {
+			add(reportCell{group: framework, id: "-"})
+		}
+	}
+	return cells
+}
+
+// mitreTacticName names a tactic of the enterprise matrix, or after its tag
+func mitreTacticName(id, tagged string) string {
+	for _, t := range mitreTactics {
+		if t.id == id {
+			return t.name
+		}
+	}
+	return tagged
+}
+
+// complianceControl splits a tag into its framework and control, e.g.
+// NIST_800-53_SI-4 into NIST and 800-53 SI-4. The framework is empty if the
+// tag is not a compliance tag.
+func complianceControl(tag string) (string, string) {
+	if m := mitreTag.FindStringSubmatch(tag); m != nil {
+		return "MITRE", strings.ToUpper(m[1])
+	}
+	m := complianceTag.FindStringSubmatch(tag)
+	if m == nil {
+		return "", ""
+	}
+	framework := strings.ToUpper(m[1])
+	if name, ok := complianceFrameworks[framework]; ok {
+		framework = name
+	}
+	return framework, strings.ReplaceAll(m[2], "_", " ")
+}
+
+// =============== //
+// == Rendering == //
+// =============== //
+
+// reportData is the rendered form of a TagReport
+type reportData struct {
+	Title     string
+	GroupName string
+	IDName    string
+	Generated string
+	From      string
+	To        string
+	Alerts    int
+	Tagged    int
+	Groups    []reportGroup
+}
+
+type reportGroup struct {
+	Name string
+	Hits int
+	Rows []reportLine
+}
+
+type reportLine struct {
+	ID        string
+	Name      string
+	Hits      int
+	Workloads []string
+	More      int
+	Policies  []string
+}
+
+func (l reportLine) workloads() string {
+	s := strings.Join(l.Workloads, ", ")
+	if l.More > 0 {
+		s += fmt.Sprintf(" and %d more", l.More)
+	}
+	return s
+}
+
+// data sorts the rows into their groups, the tactics in the order of the
+// matrix and the frameworks by name, and the rows by hits
+func (r *TagReport) data() reportData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := reportData{
+		Title:     "MITRE ATT&CK Report",
+		GroupName: "Tactic",
+		IDName:    "Technique",
+		Generated: time.Now().Format(time.DateTime),
+		Alerts:    r.alerts,
+		Tagged:    r.tagged,
+	}
+	if r.kind == ReportCompliance {
+		d.Title, d.GroupName, d.IDName = "Compliance Report", "Framework", "Control"
+	}
+	if !r.first.IsZero() {
+		d.From, d.To = r.first.Format(time.DateTime), r.last.Format(time.DateTime)
+	}
+
+	groups := map[string]*reportGroup{}
+	for _, row := range r.rows {
+		g, ok := groups[row.group]
+		if !ok {
+			g = &reportGroup{Name: row.group}
+			groups[row.group] = g
+		}
+		g.Hits += row.hits
+		g.Rows = append(g.Rows, row.line())
+	}
+	for _, g := range groups {
+		sort.Slice(g.Rows, func(i, j int) bool {
+			if g.Rows[i].Hits != g.Rows[j].Hits {
+				return g.Rows[i].Hits > g.Rows[j].Hits
+			}
+			return g.Rows[i].ID < g.Rows[j].ID
+		})
+		d.Groups = append(d.Groups, *g)
+	}
+	sort.Slice(d.Groups, func(i, j int) bool {
+		oi, oj := groupOrder(d.Groups[i].Name), groupOrder(d.Groups[j].Name)
+		if oi != oj {
+			return oi < oj
+		}
+		return d.Groups[i].Name < d.Groups[j].Name
+	})
+	return d
+}
+
+// groupOrder places the tactics in the order of the matrix, then the other
+// groups, then the unmapped techniques
+func groupOrder(group string) int {
+	for i, t := range mitreTactics {
+		if strings.HasPrefix(group, t.id+" ") {
+			return i
+		}
+	}
+	if group == unmappedGroup {
+		return len(mitreTactics) + 1
+	}
+	return len(mitreTactics)
+}
+
+func (row *reportRow) line() reportLine {
+	l := reportLine{ID: row.id, Name: row.name, Hits: row.hits}
+	workloads := make([]string, 0, len(row.workloads))
+	for w := range row.workloads {
+		workloads = append(workloads, w)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if row.workloads[workloads[i]] != row.workloads[workloads[j]] {
+			return row.workloads[workloads[i]] > row.workloads[workloads[j]]
+		}
+		return workloads[i] < workloads[j]
+	})
+	if len(workloads) > reportWorkloads {
+		l.More = len(workloads) - reportWorkloads
+		workloads = workloads[:reportWorkloads]
+	}
+	for _, w := range workloads {
+		l.Workloads = append(l.Workloads, fmt.Sprintf("%s (%d)", w, row.workloads[w]))
+	}
+	for p := range row.policies {
+		l.Policies = append(l.Policies, p)
+	}
+	sort.Strings(l.Policies)
+	return l
+}
+
+// Write renders the report in a format of ReportFormats
+func (r *TagReport) Write(w io.Writer, format string) error {
+	d := r.data()
+	switch format {
+	case "", "text":
+		return writeReportText(w, d)
+	case "markdown":
+		return writeReportMarkdown(w, d)
+	case "html":
+		return reportTemplate.Execute(w, d)
+	}
+	return ValidReportFormat(format)
+}
+
+func writeReportText(w io.Writer, d reportData) error {
+	fmt.Fprintf(w, "== %s ==\n", d.Title)
+	fmt.Fprintf(w, "%d of %d alerts tagged", d.Tagged, d.Alerts)
+	if d.From != "" {
+		fmt.Fprintf(w, ", %s .. %s", d.From, d.To)
+	}
+	fmt.Fprintln(w)
+
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{d.GroupName, d.IDName, "Name", "Hits", "Workloads", "Policies"})
+	for _, g := range d.Groups {
+		for i, l := range g.Rows {
+			group := ""
+			if i == 0 {
+				group = g.Name
+			}
+			table.Append([]string{group, l.ID, l.Name, strconv.Itoa(l.Hits), l.workloads(), strings.Join(l.Policies, ", ")})
+		}
+	}
+	table.Render()
+	return nil
+}
+
+func writeReportMarkdown(w io.Writer, d reportData) error {
+	cell := func(s string) string {
+		return strings.ReplaceAll(s, "|", `\|`)
+	}
+	fmt.Fprintf(w, "# %s\n\n", d.Title)
+	fmt.Fprintf(w, "%d of %d alerts tagged", d.Tagged, d.Alerts)
+	if d.From != "" {
+		fmt.Fprintf(w, ", %s .. %s", d.From, d.To)
+	}
+	fmt.Fprintf(w, ". Generated %s.\n", d.Generated)
+	for _, g := range d.Groups {
+		fmt.Fprintf(w, "\n## %s (%d hits)\n\n", cell(g.Name), g.Hits)
+		fmt.Fprintf(w, "| %s | Name | Hits | Workloads | Policies |\n", d.IDName)
+		fmt.Fprintln(w, "|---|---|---:|---|---|")
+		for _, l := range g.Rows {
+			fmt.Fprintf(w, "| %s | %s | %d | %s | %s |\n",
+				cell(l.ID), cell(l.Name), l.Hits, cell(l.workloads()), cell(strings.Join(l.Policies, ", ")))
+		}
+	}
+	return nil
+}
+
+// close writes the report to ReportPath, or to stdout
+func (r *TagReport) close(o Options) {
+	if r == nil {
+		return
+	}
+	w := io.Writer(os.Stdout)
+	if o.ReportPath != "" {
+		// #nosec
+		file, err := os.Create(filepath.Clean(o.ReportPath))
+		if err != nil {
+			fmt.Fprintf(o.stderr(), "Failed to write the report (%s)\n", err.Error())
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		w = file
+	}
+	if err := r.Write(w, o.ReportFormat); err != nil {
+		fmt.Fprintf(o.stderr(), "Failed to write the report (%s)\n", err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+var reportAlerts = []*pb.Alert{
+	{NamespaceName: "prod", PodName: "web-1", PolicyName: "block-masquerade",
+		Tags: "MITRE,MITRE_TA0005_defense_evasion,MITRE_T1036_masquerading,NIST,NIST_800-53_SI-4"},
+	{NamespaceName: "prod", PodName: "web-2", PolicyName: "block-masquerade",
+		ATags: []string{"MITRE_TA0005_defense_evasion", "MITRE_T1036_masquerading", "PCI_DSS"}},
+	{HostName: "node-1", PolicyName: "audit-discovery", Tags: "T1082_system_information_discovery,CIS"},
+	{NamespaceName: "dev", PodName: "api", PolicyName: "untagged"},
+}
+
+func TestTagReport(t *testing.T) {
+	mitre, err := NewTagReport(ReportMitre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compliance, err := NewTagReport(ReportCompliance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alert := range reportAlerts {
+		arr, _ := json.Marshal(alert)
+		var res map[string]interface{}
+		if err := json.Unmarshal(arr, &res); err != nil {
+			t.Fatal(err)
+		}
+		mitre.Add("Alert", res)
+		compliance.Add("Alert", res)
+	}
+	mitre.Add("Log", map[string]interface{}{"Tags": "MITRE_T1082"})
+
+	d := mitre.data()
+	if d.Alerts != 4 || d.Tagged != 3 || len(d.Groups) != 2 {
+		t.Fatalf("unexpected report %+v", d)
+	}
+	evasion := d.Groups[0]
+	if evasion.Name != "TA0005 Defense Evasion" || evasion.Hits != 2 || len(evasion.Rows) != 1 {
+		t.Fatalf("unexpected tactic %+v", evasion)
+	}
+	if row := evasion.Rows[0]; row.ID != "T1036" || row.Name != "masquerading" ||
+		strings.Join(row.Workloads, ",") != "prod/web-1 (1),prod/web-2 (1)" || strings.Join(row.Policies, ",") != "block-masquerade" {
+		t.Errorf("unexpected technique %+v", row)
+	}
+	if unmapped := d.Groups[1]; unmapped.Name != unmappedGroup || unmapped.Rows[0].ID != "T1082" ||
+		unmapped.Rows[0].Workloads[0] != "host/node-1 (1)" {
+		t.Errorf("unexpected unmapped techniques %+v", unmapped)
+	}
+
+	got := map[string]string{}
+	for _, g := range compliance.data().Groups {
+		var ids []string
+		for _, row := range g.Rows {
+			ids = append(ids, row.ID)
+		}
+		got[g.Name] = strings.Join(ids, ",")
+	}
+	want := map[string]string{"MITRE": "T1036,TA0005,T1082", "NIST": "800-53 SI-4", "PCI-DSS": "-", "CIS": "-"}
+	if len(got) != len(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expected %s controls %s, got %s", k, v, got[k])
+		}
+	}
+
+	for format, expected := range map[string][]string{
+		"text":     {"== MITRE ATT&CK Report ==", "3 of 4 alerts tagged", "TA0005 Defense Evasion", "T1036"},
+		"markdown": {"# MITRE ATT&CK Report", "## TA0005 Defense Evasion (2 hits)", "| T1036 | masquerading | 2 |"},
+		"html":     {"<title>MITRE ATT&amp;CK Report</title>", "<td>T1036</td>", "prod/web-1 (1)"},
+	} {
+		var out bytes.Buffer
+		if err := mitre.Write(&out, format); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range expected {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("expected %q in the %s report:\n%s", s, format, out.String())
+			}
+		}
+	}
+
+	if _, err := NewTagReport("nist"); err == nil {
+		t.Error("expected an error for an unknown report")
+	}
+	if err := mitre.Write(&bytes.Buffer{}, "pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestTagReportObserver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	o := Options{LogPath: "none", Sinks: []string{}, LogFilter: "policy", Report: ReportMitre,
+		ReportFormat: "html", ReportPath: path, Filter: "PolicyName!=untagged"}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	for _, alert := range reportAlerts {
+		arr, _ := json.Marshal(alert)
+		handleTelemetry(arr, "Alert", o)
+	}
+	o.release()
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the filtered out alert is not counted
+	if !strings.Contains(string(out), "3 of 3 alerts tagged") {
+		t.Errorf("unexpected report:\n%s", out)
+	}
+
+	if err := (&Options{Report: ReportMitre, ReportFormat: "pdf"}).prepare(); err == nil {
+		t.Error("expected an error for an unknown report format")
+	}
+}
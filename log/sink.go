@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkEvent is a telemetry event handed to an output sink
+type SinkEvent struct {
+	Type string // "Alert"/"Log"
+	Data []byte // json marshalled alert/log
+	Text string // event rendered in the selected output format
+}
+
+// Sink is an output destination for alerts and logs. Implementations must be
+// safe for concurrent use since alerts and logs are watched concurrently.
+type Sink interface {
+	Write(ev SinkEvent) error
+	Close() error
+}
+
+// SinkUsage describes the accepted --sink specifications
+const SinkUsage = `Output sink for alerts and logs, may be repeated:
+  stdout
+  file:<path>[,maxSize=<MB>][,maxAge=<duration|Nd>][,maxBackups=<n>][,compress]
+  syslog:<udp|tcp|unix>://<addr>[,tag=<app-name>][,facility=<0-23>]
+  webhook:<url>[,batch=<n>][,flush=<duration>][,retries=<n>][,timeout=<duration>][,header=<Key:Value>]
+  otlp:<grpc|grpcs|http|https>://<host:port>[,batch=<n>][,flush=<duration>][,retries=<n>][,timeout=<duration>][,header=<Key:Value>]`
+
+// NewSink creates a sink from its specification, see SinkUsage. Its
+// warnings go to os.Stderr.
+func NewSink(spec string) (Sink, error) {
+	return newSink(spec, os.Stderr)
+}
+
+// newSink creates a sink whose warnings go to warn
+func newSink(spec string, warn io.Writer) (Sink, error) {
+	kind, target, opts, err := parseSinkSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "stdout":
+		return &stdoutSink{}, nil
+	case "file":
+		return newFileSink(target, opts, warn)
+	case "syslog":
+		return newSyslogSink(target, opts)
+	case "webhook":
+		return newWebhookSink(target, opts, warn)
+	case "otlp":
+		return newOTLPSink(target, opts, warn)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", kind)
+}
+
+// NewSinks creates the sinks selected by --logPath and --sink, their warnings
+// go to Options.Stderr
+func NewSinks(o Options) ([]Sink, error) {
+	var sinks []Sink
+	switch o.LogPath {
+	case "", "none":
+	case "stdout":
+		sinks = append(sinks, &stdoutSink{})
+	default:
+		s, err := newFileSink(o.LogPath, nil, o.stderr())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	for _, spec := range o.Sinks {
+		s, err := newSink(spec, o.stderr())
+		if err != nil {
+			CloseSinks(sinks, o.stderr())
+			return nil, fmt.Errorf("invalid sink %q: %w", spec, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// CloseSinks flushes and closes all the given sinks, failures go to warn
+func CloseSinks(sinks []Sink, warn io.Writer) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			fmt.Fprintf(warn, "Failed to close sink (%s)\n", err.Error())
+		}
+	}
+}
+
+// sinkOptions are the options of all the sinks, see SinkUsage
+var sinkOptions = map[string]bool{
+	"maxsize": true, "maxage": true, "maxbackups": true, "compress": true,
+	"tag": true, "facility": true,
+	"batch": true, "flush": true, "retries": true, "timeout": true, "header": true,
+}
+
+// parseSinkSpec splits "<kind>:<target>,key=value,..." into its parts. Only
+// the known options are split off, so that the target, e.g. a path or a URL,
+// and the values, e.g. of headers, may have commas in them.
+func parseSinkSpec(spec string) (string, string, map[string]string, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "" {
+		return "", "", nil, errors.New("empty sink type")
+	}
+
+	parts := strings.Split(rest, ",")
+	target := parts[0]
+	opts := map[string]string{}
+	last := ""
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			v = "true"
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !sinkOptions[k] {
+			// a comma in the target or in the last value
+			if last == "" {
+				target += "," + p
+			} else {
+				opts[last] += "," + p
+			}
+			continue
+		}
+		if prev, dup := opts[k]; dup {
+			// repeated options, e.g. several headers, are newline separated
+			v = prev + "\n" + v
+		}
+		opts[k] = v
+		last = k
+	}
+	if kind != "stdout" && target == "" {
+		return "", "", nil, fmt.Errorf("%s sink requires a target", kind)
+	}
+	return kind, target, opts, nil
+}
+
+func sinkOptInt(opts map[string]string, key string, def int) (int, error) {
+	v, ok := opts[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return n, nil
+}
+
+func sinkOptDuration(opts map[string]string, key string, def time.Duration) (time.Duration, error) {
+	v, ok := opts[key]
+	if !ok {
+		return def, nil
+	}
+	// support days, which time.ParseDuration does not
+	if days, found := strings.CutSuffix(v, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q", key, v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return d, nil
+}
+
+func sinkOptBool(opts map[string]string, key string) (bool, error) {
+	v, ok := opts[key]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return b, nil
+}
+
+// ================= //
+// == Stdout Sink == //
+// ================= //
+
+type stdoutSink struct {
+	mu sync.Mutex
+}
+
+func (s *stdoutSink) Write(ev SinkEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Print(ev.Text)
+	return err
+}
+
+func (s *stdoutSink) Close() error {
+	return nil
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	batchMaxBackoff = 30 * time.Second
+	batchQueueSize  = 1024
+)
+
+// batchSender queues the events of a remote sink and sends them in batches.
+// A batch is sent once it is full or the flush interval elapses; failed
+// batches are retried with exponential backoff if send returns a
+// retryableError. The sinks only encode and send a batch. Events written
+// while the queue is full are dropped, so that a slow endpoint does not stall
+// the streams, and reported along with the batches.
+type batchSender struct {
+	name    string // of the sink, in errors
+	batch   int
+	flush   time.Duration
+	retries int
+	backoff time.Duration
+	send    func(events []SinkEvent) error
+	warn    io.Writer // where failures and drops go
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan SinkEvent
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+type retryableError struct {
+	error
+}
+
+// newBatchSender reads the batch, flush and retries options of a sink, start
+// starts sending
+func newBatchSender(name string, opts map[string]string, send func([]SinkEvent) error, warn io.Writer) (*batchSender, error) {
+	bs := &batchSender{
+		name:    name,
+		backoff: time.Second,
+		send:    send,
+		warn:    warn,
+		queue:   make(chan SinkEvent, batchQueueSize),
+		done:    make(chan struct{}),
+	}
+	var err error
+	if bs.batch, err = sinkOptInt(opts, "batch", 100); err != nil {
+		return nil, err
+	}
+	if bs.batch == 0 {
+		bs.batch = 1
+	}
+	if bs.flush, err = sinkOptDuration(opts, "flush", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if bs.retries, err = sinkOptInt(opts, "retries", 3); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (bs *batchSender) start() {
+	go bs.run()
+}
+
+func (bs *batchSender) Write(ev SinkEvent) error {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	if bs.closed {
+		return fmt.Errorf("%s is closed", bs.name)
+	}
+	select {
+	case bs.queue <- ev:
+	default:
+		bs.dropped.Add(1)
+	}
+	return nil
+}
+
+// close sends the pending events and stops the sender
+func (bs *batchSender) close() {
+	bs.mu.Lock()
+	if !bs.closed {
+		bs.closed = true
+		close(bs.queue)
+	}
+	bs.mu.Unlock()
+	<-bs.done
+}
+
+func (bs *batchSender) run() {
+	defer close(bs.done)
+
+	ticker := time.NewTicker(bs.flush)
+	defer ticker.Stop()
+
+	var reported uint64
+	pending := make([]SinkEvent, 0, bs.batch)
+	send := func() {
+		if dropped := bs.dropped.Load(); dropped > reported {
+			fmt.Fprintf(bs.warn, "Dropped %d events for the slow %s (%d in total)\n", dropped-reported, bs.name, dropped)
+			reported = dropped
+		}
+		if len(pending) == 0 {
+			return
+		}
+		if err := bs.retry(pending); err != nil {
+			fmt.Fprintf(bs.warn, "Failed to send %d events to the %s (%s)\n", len(pending), bs.name, err.Error())
+		}
+		pending = pending[:0]
+	}
+
+	for {
+		select {
+		case ev, ok := <-bs.queue:
+			if !ok {
+				send()
+				return
+			}
+			pending = append(pending, ev)
+			if len(pending) >= bs.batch {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// retry sends a batch, retrying on retryable errors
+func (bs *batchSender) retry(events []SinkEvent) error {
+	backoff := bs.backoff
+	for attempt := 0; ; attempt++ {
+		err := bs.send(events)
+		if err == nil {
+			return nil
+		}
+		if _, retry := err.(retryableError); !retry || attempt >= bs.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > batchMaxBackoff {
+			backoff = batchMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// fileSink appends events to a file which is kept open and rotated once it
+// grows beyond maxSize. Rotated files are named <name>-<time><ext>, or
+// <name>-<time>.<n><ext> if rotated several times within a millisecond, and
+// are optionally gzipped; old backups are removed by count and by age.
+type fileSink struct {
+	mu sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	warn       io.Writer // where failures of the background work go
+
+	file   *os.File // nil after a failed rotation, reopened by Write
+	size   int64
+	closed bool
+
+	// background compression and pruning of rotated files
+	wg   sync.WaitGroup
+	bgMu sync.Mutex
+}
+
+func newFileSink(path string, opts map[string]string, warn io.Writer) (*fileSink, error) {
+	maxSizeMB, err := sinkOptInt(opts, "maxsize", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := sinkOptDuration(opts, "maxage", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := sinkOptInt(opts, "maxbackups", 0)
+	if err != nil {
+		return nil, err
+	}
+	compress, err := sinkOptBool(opts, "compress")
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &fileSink{
+		path:       filepath.Clean(path),
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress,
+		warn:       warn,
+	}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *fileSink) open() error {
+	// #nosec
+	file, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open a file (%s, %s)", fs.path, err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	fs.file = file
+	fs.size = info.Size()
+	return nil
+}
+
+func (fs *fileSink) Write(ev SinkEvent) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.closed {
+		return fmt.Errorf("file sink %s is closed", fs.path)
+	}
+	if fs.file == nil {
+		if err := fs.open(); err != nil {
+			return err
+		}
+	}
+	if fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(ev.Text)) > fs.maxSize {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := fs.file.WriteString(ev.Text)
+	fs.size += int64(n)
+	return err
+}
+
+func (fs *fileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var err error
+	if fs.file != nil {
+		err = fs.file.Close()
+		fs.file = nil
+	}
+	fs.closed = true
+	fs.wg.Wait()
+	return err
+}
+
+// rotate moves the current file aside and opens a new one. If the file
+// cannot be moved, it is reopened and written to further.
+func (fs *fileSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+	fs.file = nil
+
+	ext := filepath.Ext(fs.path)
+	prefix := strings.TrimSuffix(fs.path, ext) + "-"
+	stamp := time.Now().Format(backupTimeFormat)
+	backup := prefix + stamp + ext
+	for n := 1; fileExists(backup) || fileExists(backup+".gz"); n++ {
+		backup = prefix + stamp + "." + strconv.Itoa(n) + ext
+	}
+	if err := os.Rename(fs.path, backup); err != nil {
+		if oerr := fs.open(); oerr != nil {
+			return oerr
+		}
+		return fmt.Errorf("failed to rotate %s: %w", fs.path, err)
+	}
+	if err := fs.open(); err != nil {
+		return err
+	}
+
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		fs.bgMu.Lock()
+		defer fs.bgMu.Unlock()
+		if fs.compress {
+			// the backup may already be pruned by a later rotation
+			if err := gzipFile(backup); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(fs.warn, "Failed to compress %s (%s)\n", backup, err.Error())
+			}
+		}
+		fs.prune(prefix, ext)
+	}()
+	return nil
+}
+
+// prune removes the backups beyond maxBackups or older than maxAge
+func (fs *fileSink) prune(prefix, ext string) {
+	if fs.maxBackups == 0 && fs.maxAge == 0 {
+		return
+	}
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		ts   time.Time
+		seq  int
+	}
+	var backups []backup
+	for _, m := range matches {
+		stamp := strings.TrimPrefix(m, prefix)
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		stamp = strings.TrimSuffix(stamp, ext)
+		ts, seq, ok := parseBackupStamp(stamp)
+		if !ok {
+			continue
+		}
+		backups = append(backups, backup{path: m, ts: ts, seq: seq})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].ts.Equal(backups[j].ts) {
+			return backups[i].ts.After(backups[j].ts)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+
+	for i, b := range backups {
+		tooMany := fs.maxBackups > 0 && i >= fs.maxBackups
+		tooOld := fs.maxAge > 0 && time.Since(b.ts) > fs.maxAge
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(fs.warn, "Failed to remove %s (%s)\n", b.path, err.Error())
+			}
+		}
+	}
+}
+
+// parseBackupStamp parses the <time> or <time>.<n> of a backup name
+func parseBackupStamp(stamp string) (time.Time, int, bool) {
+	seq := 0
+	if len(stamp) > len(backupTimeFormat) {
+		n, found := strings.CutPrefix(stamp[len(backupTimeFormat):], ".")
+		var err error
+		if seq, err = strconv.Atoi(n); !found || err != nil {
+			return time.Time{}, 0, false
+		}
+		stamp = stamp[:len(backupTimeFormat)]
+	}
+	ts, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return ts, seq, true
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	// #nosec
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	// #nosec
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpResourceFields map the fields identifying where an event comes from to
+// the resource attributes of the OpenTelemetry semantic conventions
+var otlpResourceFields = []struct{ field, attr string }{
+	{"ClusterContext", "k8s.cluster.name"},
+	{"NamespaceName", "k8s.namespace.name"},
+	{"PodName", "k8s.pod.name"},
+	{"ContainerName", "k8s.container.name"},
+	{"ContainerID", "container.id"},
+	{"ContainerImage", "container.image.name"},
+	{"NodeName", "k8s.node.name"},
+	{"HostName", "host.name"},
+}
+
+// otlpScope names karmor as the instrumentation scope of the log records
+const otlpScope = "github.com/kubearmor/kubearmor-client"
+
+// otlpSink exports events as OTLP log records to an OpenTelemetry Collector,
+// over gRPC or HTTP, in batches as the webhook sink, see batchSender
+type otlpSink struct {
+	*batchSender
+
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+
+	export func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error
+	conn   *grpc.ClientConn
+}
+
+// newOTLPSink creates a sink for grpc://, grpcs://, http:// or https://
+// endpoints, HTTP ones default to the /v1/logs path
+func newOTLPSink(target string, opts map[string]string, warn io.Writer) (*otlpSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected grpc://host:port or http://host:port", target)
+	}
+
+	ot := &otlpSink{
+		endpoint: target,
+		headers:  map[string]string{},
+	}
+	if ot.batchSender, err = newBatchSender("OTLP sink "+target, opts, ot.send, warn); err != nil {
+		return nil, err
+	}
+	if ot.timeout, err = sinkOptDuration(opts, "timeout", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if hdrs, ok := opts["header"]; ok {
+		for _, h := range strings.Split(hdrs, "\n") {
+			k, v, found := strings.Cut(h, ":")
+			if !found {
+				return nil, fmt.Errorf("invalid header %q, expected Key:Value", h)
+			}
+			ot.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	switch u.Scheme {
+	case "grpc", "grpcs":
+		creds := insecure.NewCredentials()
+		if u.Scheme == "grpcs" {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		ot.conn = conn
+		ot.export = ot.exportGRPC(collogspb.NewLogsServiceClient(conn))
+	case "http", "https":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/logs"
+		}
+		ot.endpoint = u.String()
+		ot.export = ot.exportHTTP(&http.Client{Timeout: ot.timeout})
+	default:
+		return nil, fmt.Errorf("unsupported OTLP scheme %q, use grpc, grpcs, http or https", u.Scheme)
+	}
+
+	ot.start()
+	return ot, nil
+}
+
+// Close flushes the pending events and stops the exporter
+func (ot *otlpSink) Close() error {
+	ot.close()
+	if ot.conn != nil {
+		return ot.conn.Close()
+	}
+	return nil
+}
+
+// send exports a batch, transient failures are retried
+func (ot *otlpSink) send(events []SinkEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ot.timeout)
+	defer cancel()
+	return ot.export(ctx, otlpRequest(events, time.Now()))
+}
+
+func (ot *otlpSink) exportGRPC(client collogspb.LogsServiceClient) func(context.Context, *collogspb.ExportLogsServiceRequest) error {
+	return func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		if len(ot.headers) != 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(ot.headers))
+		}
+		resp, err := client.Export(ctx, req)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+				return retryableError{err}
+			}
+			return err
+		}
+		return otlpPartialSuccess(resp)
+	}
+}
+
+func (ot *otlpSink) exportHTTP(client *http.Client) func(context.Context, *collogspb.ExportLogsServiceRequest) error {
+	return func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, ot.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range ot.headers {
+			hreq.Header.Set(k, v)
+		}
+		hreq.Header.Set("Content-Type", "application/x-protobuf")
+
+		resp, err := client.Do(hreq)
+		if err != nil {
+			return retryableError{err}
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			var out collogspb.ExportLogsServiceResponse
+			if len(data) != 0 && proto.Unmarshal(data, &out) == nil {
+				return otlpPartialSuccess(&out)
+			}
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+			return retryableError{fmt.Errorf("collector returned %s", resp.Status)}
+		}
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+}
+
+// otlpPartialSuccess reports the records the collector rejected, they are
+// not retried
+func otlpPartialSuccess(resp *collogspb.ExportLogsServiceResponse) error {
+	ps := resp.GetPartialSuccess()
+	if ps == nil || ps.GetRejectedLogRecords() == 0 {
+		return nil
+	}
+	return fmt.Errorf("collector rejected %d log records: %s", ps.GetRejectedLogRecords(), ps.GetErrorMessage())
+}
+
+// ================ //
+// == Conversion == //
+// ================ //
+
+// otlpRequest converts events to log records, grouped by resource
+func otlpRequest(events []SinkEvent, now time.Time) *collogspb.ExportLogsServiceRequest {
+	req := &collogspb.ExportLogsServiceRequest{}
+	byResource := map[string]*logspb.ScopeLogs{}
+
+	for _, ev := range events {
+		var res map[string]interface{}
+		if err := json.Unmarshal(ev.Data, &res); err != nil {
+			continue
+		}
+
+		var resAttrs []*commonpb.KeyValue
+		var key strings.Builder
+		for _, rf := range otlpResourceFields {
+			v := fieldString(res, rf.field)
+			if v != "" {
+				resAttrs = append(resAttrs, otlpString(rf.attr, v))
+			}
+			key.WriteString(v)
+			key.WriteByte(0)
+			delete(res, rf.field)
+		}
+		scope, ok := byResource[key.String()]
+		if !ok {
+			scope = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: otlpScope}}
+			req.ResourceLogs = append(req.ResourceLogs, &logspb.ResourceLogs{
+				Resource:  &resourcepb.Resource{Attributes: resAttrs},
+				ScopeLogs: []*logspb.ScopeLogs{scope},
+			})
+			byResource[key.String()] = scope
+		}
+		scope.LogRecords = append(scope.LogRecords, otlpRecord(ev.Type, res, now))
+	}
+	return req
+}
+
+// otlpRecord converts an event without its resource fields
+func otlpRecord(t string, res map[string]interface{}, now time.Time) *logspb.LogRecord {
+	number, text := otlpSeverity(t, fieldString(res, "Severity"))
+	rec := &logspb.LogRecord{
+		TimeUnixNano:         uint64(eventTime(res).UnixNano()),
+		ObservedTimeUnixNano: uint64(now.UnixNano()),
+		SeverityNumber:       number,
+		SeverityText:         text,
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: otlpBody(res)}},
+		Attributes:           []*commonpb.KeyValue{otlpString("kubearmor.type", t)},
+	}
+
+	keys := make([]string, 0, len(res))
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := otlpValue(res[k]); v != nil {
+			rec.Attributes = append(rec.Attributes, &commonpb.KeyValue{Key: "kubearmor." + k, Value: v})
+		}
+	}
+	return rec
+}
+
+// otlpSeverity maps the KubeArmor severity of alerts, 1 to 10, to the
+// OpenTelemetry ranges, logs have none and are informational
+func otlpSeverity(t, severity string) (logspb.SeverityNumber, string) {
+	n, err := strconv.Atoi(severity)
+	switch {
+	case t != "Alert":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+	case err != nil || n <= 0:
+		// alerts without a severity still deserve attention
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+	case n <= 2:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+	case n <= 4:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+	case n <= 7:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, "ERROR"
+	}
+	return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL, "FATAL"
+}
+
+// otlpBody is the policy message of alerts, or what the process did
+func otlpBody(res map[string]interface{}) string {
+	if msg := fieldString(res, "Message"); msg != "" {
+		return msg
+	}
+	return strings.TrimSpace(fieldString(res, "Operation") + " " + fieldString(res, "Resource"))
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// otlpValue converts a JSON value, objects and arrays are kept as JSON
+func otlpValue(v interface{}) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case nil:
+		return nil
+	}
+	arr, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: string(arr)}}
+}
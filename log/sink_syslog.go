@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog severities used for telemetry events
+const (
+	syslogWarning = 4
+	syslogInfo    = 6
+)
+
+// syslogSink sends events as RFC5424 messages over udp, tcp or a unix socket.
+// Stream transports use octet-counting framing (RFC6587).
+type syslogSink struct {
+	mu sync.Mutex
+
+	network  string
+	addr     string
+	tag      string
+	facility int
+	hostname string
+
+	conn   net.Conn
+	stream bool // true for tcp and unix stream sockets
+}
+
+func newSyslogSink(target string, opts map[string]string) (*syslogSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	ss := &syslogSink{network: u.Scheme, addr: u.Host, tag: "karmor"}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing syslog address in %q", target)
+		}
+	case "unix":
+		ss.addr = u.Path
+		if ss.addr == "" {
+			return nil, fmt.Errorf("missing syslog socket path in %q", target)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog transport %q, use udp, tcp or unix", u.Scheme)
+	}
+
+	if tag, ok := opts["tag"]; ok && tag != "" {
+		ss.tag = tag
+	}
+	if ss.facility, err = sinkOptInt(opts, "facility", 1); err != nil {
+		return nil, err
+	}
+	if ss.facility > 23 {
+		return nil, fmt.Errorf("invalid facility %d", ss.facility)
+	}
+	if ss.hostname, err = os.Hostname(); err != nil || ss.hostname == "" {
+		ss.hostname = "-"
+	}
+
+	if err := ss.connect(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *syslogSink) connect() error {
+	var err error
+	if ss.network == "unix" {
+		// syslog daemons usually listen on datagram sockets
+		ss.conn, err = net.DialTimeout("unixgram", ss.addr, 5*time.Second)
+		if err == nil {
+			ss.stream = false
+			return nil
+		}
+	}
+	ss.conn, err = net.DialTimeout(ss.network, ss.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog %s://%s: %w", ss.network, ss.addr, err)
+	}
+	ss.stream = ss.network != "udp"
+	return nil
+}
+
+// format renders an RFC5424 message
+func (ss *syslogSink) format(ev SinkEvent) string {
+	severity := syslogInfo
+	if ev.Type == "Alert" {
+		severity = syslogWarning
+	}
+	msgID := ev.Type
+	if msgID == "" {
+		msgID = "-"
+	}
+	// collapse multi-line output formats into a single line
+	msg := strings.Join(strings.Fields(ev.Text), " ")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		ss.facility*8+severity,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		ss.hostname, ss.tag, os.Getpid(), msgID, msg)
+}
+
+func (ss *syslogSink) Write(ev SinkEvent) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	msg := ss.format(ev)
+
+	// retry once on a fresh connection, e.g. after a collector restart
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if ss.conn == nil {
+			if err = ss.connect(); err != nil {
+				continue
+			}
+		}
+		frame := msg
+		if ss.stream {
+			frame = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err = ss.conn.Write([]byte(frame)); err == nil {
+			return nil
+		}
+		_ = ss.conn.Close()
+		ss.conn = nil
+	}
+	return err
+}
+
+func (ss *syslogSink) Close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn == nil {
+		return nil
+	}
+	err := ss.conn.Close()
+	ss.conn = nil
+	return err
+}
@@ -0,0 +1,466 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "alerts.log")
+
+	s, err := NewSink("file:" + path + ",maxSize=1,maxBackups=2,compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*fileSink)
+
+	line := strings.Repeat("x", 400*1024) + "\n"
+	for i := 0; i < 12; i++ {
+		if err := s.Write(SinkEvent{Type: "Alert", Text: line}); err != nil {
+			t.Fatal(err)
+		}
+		// keep backup timestamps unique
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > fs.maxSize {
+		t.Errorf("active file is %d bytes, larger than maxSize", info.Size())
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "alerts-*.log.gz"))
+	if len(backups) != 2 {
+		t.Errorf("expected 2 compressed backups, found %v", backups)
+	}
+	plain, _ := filepath.Glob(filepath.Join(dir, "alerts-*.log"))
+	if len(plain) != 0 {
+		t.Errorf("expected rotated files to be compressed, found %v", plain)
+	}
+}
+
+func TestFileSinkRotationRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "alerts.log")
+
+	s, err := NewSink("file:" + path + ",maxSize=1,maxBackups=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := s.(*fileSink)
+	line := strings.Repeat("x", 700*1024) + "\n"
+
+	// rotations within a millisecond do not overwrite each other
+	for i := 0; i < 3; i++ {
+		if err := s.Write(SinkEvent{Type: "Alert", Text: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "alerts-*.log")); len(backups) != 2 {
+		t.Errorf("expected 2 backups, found %v", backups)
+	}
+
+	// the file is written further when it cannot be moved aside
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(SinkEvent{Type: "Alert", Text: line}); err == nil {
+		t.Error("expected the failed rotation to be reported")
+	}
+	if err := s.Write(SinkEvent{Type: "Alert", Text: "after rename\n"}); err != nil {
+		t.Fatalf("expected the file to be written after a failed rotation, got %v", err)
+	}
+
+	// and reopened if that failed too
+	_ = fs.file.Close()
+	fs.file = nil
+	if err := s.Write(SinkEvent{Type: "Alert", Text: "after reopen\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "after rename\nafter reopen\n" {
+		t.Errorf("unexpected file %q", data)
+	}
+	if err := s.Write(SinkEvent{Type: "Alert", Text: line}); err == nil {
+		t.Error("expected an error once closed")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSink("syslog:udp://" + pc.LocalAddr().String() + ",tag=karmor-test,facility=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Write(SinkEvent{Type: "Alert", Text: "== Alert ==\nPolicyName: block-curl\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 (16) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("unexpected priority/version in %q", msg)
+	}
+	if !strings.Contains(msg, " karmor-test ") || !strings.HasSuffix(msg, " Alert - == Alert == PolicyName: block-curl") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestWebhookSinkBatchAndRetry(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]map[string]interface{}
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var batch []map[string]interface{}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batches = append(batches, batch)
+	}))
+	defer srv.Close()
+
+	s, err := NewSink("webhook:" + srv.URL + ",batch=2,flush=1h,header=Authorization:Bearer token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*webhookSink).backoff = time.Millisecond
+
+	for _, pod := range []string{"a", "b", "c"} {
+		if err := s.Write(SinkEvent{Type: "Alert", Data: []byte(`{"PodName":"` + pod + `"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close flushes the last partial batch
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+	if batches[1][0]["PodName"] != "c" {
+		t.Errorf("unexpected last event %v", batches[1][0])
+	}
+}
+
+func TestWebhookSinkSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	s, err := NewSink("webhook:" + srv.URL + ",batch=1,retries=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 2*batchQueueSize; i++ {
+			_ = s.Write(SinkEvent{Type: "Alert", Data: []byte(`{}`)})
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing stalled on the endpoint")
+	}
+	if s.(*webhookSink).dropped.Load() == 0 {
+		t.Error("expected events to be dropped")
+	}
+	close(release)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSinksStderr(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	var stderr lockedBuffer
+	o := Options{LogPath: "none", Sinks: []string{"webhook:" + srv.URL + ",batch=1,retries=0"}, Stderr: &stderr}
+	sinks, err := NewSinks(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks[0].Write(SinkEvent{Type: "Alert", Data: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	CloseSinks(sinks, o.stderr())
+	if !strings.Contains(stderr.String(), "Failed to send 1 events to the webhook sink") {
+		t.Errorf("expected the failure on Stderr, got %q", stderr.String())
+	}
+}
+
+// fakeCollector records the OTLP exports it receives, failing the first one
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu       sync.Mutex
+	calls    int
+	requests []*collogspb.ExportLogsServiceRequest
+	auth     []string
+}
+
+func (fc *fakeCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.calls++
+	if fc.calls == 1 {
+		return nil, status.Error(codes.Unavailable, "starting")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	fc.auth = append(fc.auth, md.Get("authorization")...)
+	fc.requests = append(fc.requests, req)
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// records returns the received log records with their resource attributes
+func (fc *fakeCollector) records() ([]*logspb.LogRecord, []map[string]string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var records []*logspb.LogRecord
+	var resources []map[string]string
+	for _, req := range fc.requests {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				for _, rec := range sl.LogRecords {
+					records = append(records, rec)
+					resources = append(resources, otlpAttrs(rl.Resource.Attributes))
+				}
+			}
+		}
+	}
+	return records, resources
+}
+
+func otlpAttrs(kvs []*commonpb.KeyValue) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			attrs[kv.Key] = "int:" + strconv.FormatInt(v.IntValue, 10)
+		}
+	}
+	return attrs
+}
+
+// writeOTLP sends an alert and a log to the sink and closes it
+func writeOTLP(t *testing.T, spec string, backoff time.Duration) {
+	t.Helper()
+	s, err := NewSink(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*otlpSink).backoff = backoff
+	for _, ev := range []SinkEvent{
+		{Type: "Alert", Data: []byte(`{"NamespaceName":"prod","PodName":"web","ContainerID":"c0ffee","HostName":"node-1","PolicyName":"block-shadow","Severity":"8","Action":"Block","Message":"shadow read","Timestamp":1700000000}`)},
+		{Type: "Log", Data: []byte(`{"NamespaceName":"prod","PodName":"web","ContainerID":"c0ffee","HostName":"node-1","Operation":"Process","Resource":"/bin/ls","PID":42}`)},
+		{Type: "Alert", Data: []byte(`{"HostName":"node-2","PolicyName":"host-audit","Severity":"2"}`)},
+	} {
+		if err := s.Write(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close flushes the batch
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkOTLPRecords(t *testing.T, records []*logspb.LogRecord, resources []map[string]string) {
+	t.Helper()
+	if len(records) != 3 {
+		t.Fatalf("expected 3 log records, got %d", len(records))
+	}
+	alert, log, host := records[0], records[1], records[2]
+	if resources[0]["k8s.namespace.name"] != "prod" || resources[0]["k8s.pod.name"] != "web" ||
+		resources[0]["container.id"] != "c0ffee" || resources[0]["host.name"] != "node-1" {
+		t.Errorf("unexpected resource %v", resources[0])
+	}
+	if len(resources[2]) != 1 || resources[2]["host.name"] != "node-2" {
+		t.Errorf("unexpected host resource %v", resources[2])
+	}
+	attrs := otlpAttrs(alert.Attributes)
+	if alert.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_FATAL || alert.Body.GetStringValue() != "shadow read" ||
+		attrs["kubearmor.PolicyName"] != "block-shadow" || attrs["kubearmor.type"] != "Alert" ||
+		attrs["kubearmor.PodName"] != "" || alert.TimeUnixNano != uint64(1700000000*time.Second) {
+		t.Errorf("unexpected alert record %v", alert)
+	}
+	if log.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_INFO || log.Body.GetStringValue() != "Process /bin/ls" ||
+		otlpAttrs(log.Attributes)["kubearmor.PID"] != "int:42" {
+		t.Errorf("unexpected log record %v", log)
+	}
+	if host.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_INFO {
+		t.Errorf("unexpected severity of a low severity alert %v", host.SeverityNumber)
+	}
+}
+
+func TestOTLPSinkGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeCollector{}
+	srv := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(srv, fc)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+
+	writeOTLP(t, "otlp:grpc://"+lis.Addr().String()+",flush=1h,header=authorization:Bearer token", time.Millisecond)
+
+	records, resources := fc.records()
+	checkOTLPRecords(t, records, resources)
+	if fc.calls != 2 || len(fc.requests) != 1 || len(fc.auth) != 1 || fc.auth[0] != "Bearer token" {
+		t.Errorf("expected one retried export with the header, got %d calls and %v", fc.calls, fc.auth)
+	}
+	if len(fc.requests[0].ResourceLogs) != 2 {
+		t.Errorf("expected the records to be grouped by resource, got %d", len(fc.requests[0].ResourceLogs))
+	}
+}
+
+func TestOTLPSinkHTTP(t *testing.T) {
+	fc := &fakeCollector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req collogspb.ExportLogsServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := fc.Export(context.Background(), &req); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		arr, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(arr)
+	}))
+	defer srv.Close()
+
+	writeOTLP(t, "otlp:"+srv.URL+",batch=10,flush=1h", time.Millisecond)
+
+	records, resources := fc.records()
+	checkOTLPRecords(t, records, resources)
+	if fc.calls != 2 {
+		t.Errorf("expected the export to be retried once, got %d calls", fc.calls)
+	}
+}
+
+func TestInvalidSinks(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"kafka:broker:9092",
+		"file:",
+		"file:/tmp/x,maxSize=abc",
+		"syslog:http://collector",
+		"webhook:ftp://collector",
+		"otlp:localhost:4317",
+		"otlp:kafka://collector:9092",
+		"otlp:grpc://collector:4317,batch=x",
+	} {
+		if s, err := NewSink(spec); err == nil {
+			_ = s.Close()
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestParseSinkSpec(t *testing.T) {
+	for _, tc := range []struct {
+		spec, target string
+		opts         map[string]string
+	}{
+		{"file:/var/log/a,b.log,maxSize=1,compress", "/var/log/a,b.log", map[string]string{"maxsize": "1", "compress": "true"}},
+		{"webhook:https://hook/?ids=1,2&x=y,batch=5", "https://hook/?ids=1,2&x=y", map[string]string{"batch": "5"}},
+		{"webhook:https://hook,header=Accept:a/b,c/d,header=X-Id:1", "https://hook", map[string]string{"header": "Accept:a/b,c/d\nX-Id:1"}},
+		{"stdout", "", map[string]string{}},
+	} {
+		_, target, opts, err := parseSinkSpec(tc.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.spec, err)
+		}
+		if target != tc.target || len(opts) != len(tc.opts) {
+			t.Errorf("%q: expected %q %v, got %q %v", tc.spec, tc.target, tc.opts, target, opts)
+			continue
+		}
+		for k, v := range tc.opts {
+			if opts[k] != v {
+				t.Errorf("%q: expected %s=%q, got %q", tc.spec, k, v, opts[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookSink posts batches of events as a JSON array to an HTTP endpoint,
+// see batchSender
+type webhookSink struct {
+	*batchSender
+
+	url     string
+	headers http.Header
+	client  *http.Client
+}
+
+func newWebhookSink(target string, opts map[string]string, warn io.Writer) (*webhookSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported webhook scheme %q", u.Scheme)
+	}
+
+	ws := &webhookSink{
+		url:     target,
+		headers: http.Header{},
+	}
+	if ws.batchSender, err = newBatchSender("webhook sink "+target, opts, ws.post, warn); err != nil {
+		return nil, err
+	}
+	timeout, err := sinkOptDuration(opts, "timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ws.client = &http.Client{Timeout: timeout}
+
+	if hdrs, ok := opts["header"]; ok {
+		for _, h := range strings.Split(hdrs, "\n") {
+			k, v, found := strings.Cut(h, ":")
+			if !found {
+				return nil, fmt.Errorf("invalid header %q, expected Key:Value", h)
+			}
+			ws.headers.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+
+	ws.start()
+	return ws, nil
+}
+
+// Close flushes the pending events and stops the sender
+func (ws *webhookSink) Close() error {
+	ws.close()
+	return nil
+}
+
+// post sends a batch, network errors, 429 and 5xx responses are retried
+func (ws *webhookSink) post(events []SinkEvent) error {
+	batch := make([]json.RawMessage, 0, len(events))
+	for _, ev := range events {
+		batch = append(batch, json.RawMessage(ev.Data))
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ws.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = ws.headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return retryableError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	return fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -0,0 +1,628 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The event store is a bbolt database. Events are keyed by their time and a
+// sequence number, so that they are ordered by time, and indexed by the
+// fields of storeIndexes with keys of the field value, a NUL byte and the
+// event key.
+var (
+	storeEventsBucket = []byte("events")
+	storeIndexBucket  = []byte("index")
+	storeMetaBucket   = []byte("meta")
+
+	storeCountKey     = []byte("count")
+	storeRetentionKey = []byte("retention")
+)
+
+// storeIndexes are the indexed fields, by index name
+var storeIndexes = []struct{ name, field string }{
+	{"namespace", "NamespaceName"},
+	{"pod", "PodName"},
+	{"policy", "PolicyName"},
+	{"operation", "Operation"},
+}
+
+const (
+	// storeFlushInterval is how often the received events are written
+	storeFlushInterval = time.Second
+
+	// storeBatchSize flushes earlier when this many events are pending
+	storeBatchSize = 512
+
+	// storeLockTimeout bounds the wait for another karmor using the store
+	storeLockTimeout = 10 * time.Second
+)
+
+// DefaultStorePath returns the event store in the home directory of the user
+func DefaultStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "karmor-events.db"
+	}
+	return filepath.Join(home, ".karmor", "events.db")
+}
+
+// EventStore persists alerts and logs in a local database, see QueryEvents
+// and GetStoreStats to read it back
+type EventStore struct {
+	path      string
+	retention time.Duration
+	maxEvents uint64
+
+	db    *bolt.DB  // open until Close
+	warn  io.Writer // where failures go
+	queue chan storedEvent
+	done  chan struct{}
+	mu    sync.Mutex // serialises flushes
+
+	dropped atomic.Uint64 // while the queue was full
+}
+
+type storedEvent struct {
+	t    string
+	time time.Time
+	data []byte
+	res  map[string]interface{}
+}
+
+// OpenEventStore creates the store if needed and starts writing to it.
+// Events older than retention, and the oldest beyond maxEvents, are pruned
+// when either is set. The store is locked until Close, QueryEvents and
+// GetStoreStats wait for it meanwhile.
+func OpenEventStore(path string, retention time.Duration, maxEvents uint64) (*EventStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the event store directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: storeLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the event store %s: %w", path, err)
+	}
+	s := &EventStore{
+		path:      path,
+		retention: retention,
+		maxEvents: maxEvents,
+		db:        db,
+		warn:      os.Stderr,
+		queue:     make(chan storedEvent, 4*storeBatchSize),
+		done:      make(chan struct{}),
+	}
+	// create the buckets and prune right away
+	if err := s.flush(nil); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+// add queues an event to be written. Events added while the queue is full
+// are dropped, so that a slow disk does not stall the streams, and reported
+// along with the flushes.
+func (s *EventStore) add(t string, res map[string]interface{}, arr []byte) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- storedEvent{t: t, time: eventTime(res), data: arr, res: res}:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *EventStore) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(storeFlushInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	var batch []storedEvent
+	flush := func() {
+		if dropped := s.dropped.Load(); dropped > reported {
+			fmt.Fprintf(s.warn, "Dropped %d events for the slow event store (%d in total)\n", dropped-reported, dropped)
+			reported = dropped
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.flush(batch); err != nil {
+			fmt.Fprintf(s.warn, "Failed to store %d events (%s)\n", len(batch), err.Error())
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case ev, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= storeBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close writes the pending events and closes the store
+func (s *EventStore) Close() {
+	if s == nil {
+		return
+	}
+	close(s.queue)
+	<-s.done
+	if err := s.db.Close(); err != nil {
+		fmt.Fprintf(s.warn, "Failed to close the event store %s (%s)\n", s.path, err.Error())
+	}
+}
+
+// flush writes events and prunes the store
+func (s *EventStore) flush(events []storedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(storeEventsBucket)
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(storeMetaBucket)
+		if err != nil {
+			return err
+		}
+		index, err := tx.CreateBucketIfNotExists(storeIndexBucket)
+		if err != nil {
+			return err
+		}
+		for _, idx := range storeIndexes {
+			if _, err := index.CreateBucketIfNotExists([]byte(idx.name)); err != nil {
+				return err
+			}
+		}
+		count := getUint64(meta.Get(storeCountKey))
+
+		for _, ev := range events {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := storeKey(ev.time, seq)
+			if err := bucket.Put(key, encodeStoredEvent(ev)); err != nil {
+				return err
+			}
+			for _, idx := range storeIndexes {
+				if v := fieldString(ev.res, idx.field); v != "" {
+					if err := index.Bucket([]byte(idx.name)).Put(indexKey(v, key), nil); err != nil {
+						return err
+					}
+				}
+			}
+			count++
+		}
+
+		// prune the oldest events
+		c := bucket.Cursor()
+		cutoff := time.Time{}
+		if s.retention > 0 {
+			cutoff = time.Now().Add(-s.retention)
+		}
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			expired := !cutoff.IsZero() && keyTime(k).Before(cutoff)
+			if !expired && (s.maxEvents == 0 || count <= s.maxEvents) {
+				break
+			}
+			if err := deleteStoredEvent(bucket, index, k, v); err != nil {
+				return err
+			}
+			if count > 0 {
+				count--
+			}
+		}
+
+		if err := meta.Put(storeRetentionKey, []byte(s.retention.String())); err != nil {
+			return err
+		}
+		return meta.Put(storeCountKey, putUint64(count))
+	})
+}
+
+func deleteStoredEvent(events, index *bolt.Bucket, key, value []byte) error {
+	ev, err := decodeStoredEvent(key, value)
+	if err != nil {
+		return err
+	}
+	for _, idx := range storeIndexes {
+		if v := fieldString(ev.res, idx.field); v != "" {
+			if err := index.Bucket([]byte(idx.name)).Delete(indexKey(v, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return events.Delete(key)
+}
+
+func storeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	if len(key) < 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func indexKey(value string, key []byte) []byte {
+	return append(append([]byte(value), 0), key...)
+}
+
+func encodeStoredEvent(ev storedEvent) []byte {
+	return append([]byte(ev.t+"\n"), ev.data...)
+}
+
+func decodeStoredEvent(key, value []byte) (StoredEvent, error) {
+	t, data, ok := bytes.Cut(value, []byte("\n"))
+	if !ok {
+		return StoredEvent{}, errors.New("corrupt event in the store")
+	}
+	ev := StoredEvent{Type: string(t), Time: keyTime(key), Data: append(json.RawMessage{}, data...)}
+	if err := json.Unmarshal(data, &ev.res); err != nil {
+		return StoredEvent{}, err
+	}
+	return ev, nil
+}
+
+func getUint64(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func putUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// =========== //
+// == Query == //
+// =========== //
+
+// StoredEvent is an alert or log read from the store
+type StoredEvent struct {
+	Type string          // "Alert"/"Log"
+	Time time.Time       // time of the event
+	Data json.RawMessage // the event as received
+
+	res map[string]interface{}
+}
+
+// Field returns a field of the event as a string
+func (ev StoredEvent) Field(name string) string {
+	return fieldString(ev.res, name)
+}
+
+// StoreQuery selects events from the store
+type StoreQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Type      string // "Alert" or "Log", both if empty
+	Namespace string
+	Pod       string
+	Policy    string
+	Operation string
+	Where     string // filter expression, see CompileFilter
+	Limit     int    // the most recent events only, all if 0
+}
+
+// openStoreReadOnly opens an existing store to read from
+func openStoreReadOnly(path string) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no event store at %s, record one with karmor logs --store", path)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true, Timeout: storeLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the event store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// QueryEvents returns the events of the store matching the query, oldest
+// first
+func QueryEvents(path string, q StoreQuery) ([]StoredEvent, error) {
+	var where *Filter
+	if q.Where != "" {
+		var err error
+		if where, err = CompileFilter(q.Where); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := openStoreReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	// the most selective of the indexed fields given drives the scan
+	indexed := map[string]string{
+		"namespace": q.Namespace,
+		"pod":       q.Pod,
+		"policy":    q.Policy,
+		"operation": q.Operation,
+	}
+	scanIndex, scanValue := "", ""
+	for _, name := range []string{"pod", "policy", "namespace", "operation"} {
+		if indexed[name] != "" {
+			scanIndex, scanValue = name, indexed[name]
+			break
+		}
+	}
+
+	var out []StoredEvent
+	match := func(ev StoredEvent) bool {
+		if q.Type != "" && !strings.EqualFold(ev.Type, q.Type) {
+			return false
+		}
+		for _, idx := range storeIndexes {
+			if v := indexed[idx.name]; v != "" && ev.Field(idx.field) != v {
+				return false
+			}
+		}
+		return where == nil || where.Match(ev.res)
+	}
+	keep := func(ev StoredEvent) {
+		out = append(out, ev)
+		if q.Limit > 0 && len(out) > q.Limit {
+			out = out[1:]
+		}
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(storeEventsBucket)
+		if events == nil {
+			return nil
+		}
+		from := make([]byte, 16)
+		if !q.Since.IsZero() {
+			from = storeKey(q.Since, 0)
+		}
+		inRange := func(k []byte) bool {
+			return q.Until.IsZero() || !keyTime(k).After(q.Until)
+		}
+
+		if scanIndex == "" {
+			c := events.Cursor()
+			for k, v := c.Seek(from); k != nil && inRange(k); k, v = c.Next() {
+				ev, err := decodeStoredEvent(k, v)
+				if err != nil {
+					return err
+				}
+				if match(ev) {
+					keep(ev)
+				}
+			}
+			return nil
+		}
+
+		prefix := indexKey(scanValue, nil)
+		c := tx.Bucket(storeIndexBucket).Bucket([]byte(scanIndex)).Cursor()
+		for k, _ := c.Seek(indexKey(scanValue, from)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			if !inRange(key) {
+				break
+			}
+			v := events.Get(key)
+			if v == nil {
+				continue
+			}
+			ev, err := decodeStoredEvent(key, v)
+			if err != nil {
+				return err
+			}
+			if match(ev) {
+				keep(ev)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// StoreStats summarises the store
+type StoreStats struct {
+	Path      string                      `json:"path"`
+	Size      int64                       `json:"size"`
+	Events    int                         `json:"events"`
+	Alerts    int                         `json:"alerts"`
+	Logs      int                         `json:"logs"`
+	Oldest    time.Time                   `json:"oldest"`
+	Newest    time.Time                   `json:"newest"`
+	Retention string                      `json:"retention"`
+	Top       map[string][]StoreStatCount `json:"top"`
+}
+
+// StoreStatCount is the number of events with a value of an indexed field
+type StoreStatCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// GetStoreStats counts the events of the store, with the top values of the
+// indexed fields
+func GetStoreStats(path string, top int) (StoreStats, error) {
+	st := StoreStats{Path: path, Top: map[string][]StoreStatCount{}}
+	if fi, err := os.Stat(path); err == nil {
+		st.Size = fi.Size()
+	}
+	db, err := openStoreReadOnly(path)
+	if err != nil {
+		return st, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(storeEventsBucket)
+		if events == nil {
+			return nil
+		}
+		if meta := tx.Bucket(storeMetaBucket); meta != nil {
+			st.Retention = string(meta.Get(storeRetentionKey))
+		}
+		c := events.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			st.Events++
+			if bytes.HasPrefix(v, []byte("Alert\n")) {
+				st.Alerts++
+			} else {
+				st.Logs++
+			}
+		}
+		if k, _ := c.First(); k != nil {
+			st.Oldest = keyTime(k)
+		}
+		if k, _ := c.Last(); k != nil {
+			st.Newest = keyTime(k)
+		}
+
+		for _, idx := range storeIndexes {
+			b := tx.Bucket(storeIndexBucket).Bucket([]byte(idx.name))
+			if b == nil {
+				continue
+			}
+			var counts []StoreStatCount
+			err := b.ForEach(func(k, _ []byte) error {
+				v, _, _ := bytes.Cut(k, []byte{0})
+				if n := len(counts); n > 0 && counts[n-1].Value == string(v) {
+					counts[n-1].Count++
+				} else {
+					counts = append(counts, StoreStatCount{Value: string(v), Count: 1})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			st.Top[idx.name] = topCounts(counts, top)
+		}
+		return nil
+	})
+	return st, err
+}
+
+// topCounts returns the n highest counts, all if n is 0
+func topCounts(counts []StoreStatCount, n int) []StoreStatCount {
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// ============ //
+// == Output == //
+// ============ //
+
+// WriteEvents prints stored events as a table, or as JSON lines with
+// format "json"
+func WriteEvents(w io.Writer, events []StoredEvent, format string) error {
+	if format == "json" {
+		for _, ev := range events {
+			if _, err := fmt.Fprintf(w, "{\"Type\":%q,\"Event\":%s}\n", ev.Type, ev.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Time", "Type", "Namespace", "Pod", "Policy", "Operation", "Resource", "Action"})
+	for _, ev := range events {
+		table.Append([]string{
+			ev.Time.Local().Format(time.DateTime), ev.Type,
+			ev.Field("NamespaceName"), ev.Field("PodName"), ev.Field("PolicyName"),
+			ev.Field("Operation"), ev.Field("Resource"), ev.Field("Action"),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// WriteStoreStats prints the stats of a store as a table, or as JSON with
+// format "json"
+func WriteStoreStats(w io.Writer, st StoreStats, format string) error {
+	if format == "json" {
+		arr, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(arr))
+		return err
+	}
+
+	retention := st.Retention
+	if retention == "" || retention == "0s" {
+		retention = "unlimited"
+	}
+	fmt.Fprintf(w, "Store:     %s (%d bytes)\n", st.Path, st.Size)
+	fmt.Fprintf(w, "Events:    %d (%d alerts, %d logs)\n", st.Events, st.Alerts, st.Logs)
+	if st.Events > 0 {
+		fmt.Fprintf(w, "Oldest:    %s\n", st.Oldest.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "Newest:    %s\n", st.Newest.Local().Format(time.DateTime))
+	}
+	fmt.Fprintf(w, "Retention: %s\n", retention)
+
+	for _, idx := range storeIndexes {
+		counts := st.Top[idx.name]
+		if len(counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n== Top %s ==\n", idx.name)
+		table := tablewriter.NewWriter(w)
+		table.SetAutoWrapText(false)
+		table.SetAutoFormatHeaders(false)
+		table.SetHeader([]string{strings.ToUpper(idx.name[:1]) + idx.name[1:], "Events"})
+		for _, c := range counts {
+			table.Append([]string{c.Value, strconv.Itoa(c.Count)})
+		}
+		table.Render()
+	}
+	return nil
+}
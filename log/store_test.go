@@ -0,0 +1,162 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+// storeEvents writes alerts to a new store through the output pipeline
+func storeEvents(t *testing.T, path string, o Options, alerts ...*pb.Alert) {
+	t.Helper()
+	o.Store = path
+	o.LogPath = "none"
+	o.LogFilter = "policy"
+	o.Sinks = []string{}
+	if err := o.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range alerts {
+		arr, _ := json.Marshal(a)
+		handleTelemetry(arr, "Alert", o)
+	}
+	o.release()
+}
+
+func storedAt(ago time.Duration, a *pb.Alert) *pb.Alert {
+	a.UpdatedTime = time.Now().Add(-ago).UTC().Format(time.RFC3339Nano)
+	return a
+}
+
+func TestEventStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	storeEvents(t, path, Options{Filter: "Action!=Allow"},
+		storedAt(3*time.Hour, &pb.Alert{NamespaceName: "prod", PodName: "web", PolicyName: "block-shadow", Operation: "File", Action: "Block"}),
+		storedAt(30*time.Minute, &pb.Alert{NamespaceName: "prod", PodName: "api", PolicyName: "audit-curl", Operation: "Process", Action: "Audit"}),
+		storedAt(20*time.Minute, &pb.Alert{NamespaceName: "dev", PodName: "web", PolicyName: "block-shadow", Operation: "File", Action: "Block"}),
+		storedAt(10*time.Minute, &pb.Alert{NamespaceName: "prod", PodName: "web", PolicyName: "block-shadow", Operation: "File", Action: "Block"}),
+		storedAt(5*time.Minute, &pb.Alert{NamespaceName: "prod", PodName: "web", PolicyName: "allow-ls", Operation: "Process", Action: "Allow"}),
+	)
+
+	for _, tc := range []struct {
+		name string
+		q    StoreQuery
+		want []string
+	}{
+		{"all", StoreQuery{}, []string{"block-shadow", "audit-curl", "block-shadow", "block-shadow"}},
+		{"since", StoreQuery{Since: time.Now().Add(-time.Hour)}, []string{"audit-curl", "block-shadow", "block-shadow"}},
+		{"namespace", StoreQuery{Namespace: "prod", Since: time.Now().Add(-time.Hour)}, []string{"audit-curl", "block-shadow"}},
+		{"pod and policy", StoreQuery{Pod: "web", Policy: "block-shadow"}, []string{"block-shadow", "block-shadow", "block-shadow"}},
+		{"where", StoreQuery{Where: "Operation==Process OR NamespaceName==dev"}, []string{"audit-curl", "block-shadow"}},
+		{"limit", StoreQuery{Operation: "File", Limit: 1}, []string{"block-shadow"}},
+		{"no match", StoreQuery{Pod: "nope"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := QueryEvents(path, tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ev := range events {
+				got = append(got, ev.Field("PolicyName"))
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	st, err := GetStoreStats(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Events != 4 || st.Alerts != 4 || st.Top["pod"][0] != (StoreStatCount{Value: "web", Count: 3}) {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	var out bytes.Buffer
+	if err := WriteStoreStats(&out, st, "table"); err != nil || !strings.Contains(out.String(), "4 alerts") {
+		t.Errorf("unexpected stats output %q (%v)", out.String(), err)
+	}
+
+	if _, err := QueryEvents(path, StoreQuery{Where: "Action=="}); err == nil {
+		t.Error("expected an error for an invalid --where")
+	}
+	if _, err := QueryEvents(filepath.Join(t.TempDir(), "missing.db"), StoreQuery{}); err == nil {
+		t.Error("expected an error for a missing store")
+	}
+}
+
+func TestEventStoreRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	storeEvents(t, path, Options{StoreRetention: time.Hour, StoreMaxEvents: 2},
+		storedAt(2*time.Hour, &pb.Alert{PodName: "expired"}),
+		storedAt(30*time.Minute, &pb.Alert{PodName: "oldest"}),
+		storedAt(20*time.Minute, &pb.Alert{PodName: "older"}),
+		storedAt(10*time.Minute, &pb.Alert{PodName: "newest"}),
+	)
+
+	events, err := QueryEvents(path, StoreQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Field("PodName") != "older" || events[1].Field("PodName") != "newest" {
+		t.Errorf("unexpected events after pruning %v", events)
+	}
+	// the index entries go with the events
+	for _, pod := range []string{"expired", "oldest"} {
+		if events, err := QueryEvents(path, StoreQuery{Pod: pod}); err != nil || len(events) != 0 {
+			t.Errorf("expected %s to be pruned, got %v (%v)", pod, events, err)
+		}
+	}
+	st, err := GetStoreStats(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Events != 2 || len(st.Top["pod"]) != 2 || st.Retention != "1h0m0s" {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestEventStoreDropsWhenFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s, err := OpenEventStore(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warn lockedBuffer
+	s.warn = &warn
+
+	// a stalled flush must not block the streams adding events
+	s.mu.Lock()
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		arr, _ := json.Marshal(&pb.Alert{PodName: "web"})
+		for i := 0; i < 6*storeBatchSize; i++ {
+			s.add("Alert", map[string]interface{}{"PodName": "web"}, arr)
+		}
+	}()
+	select {
+	case <-added:
+	case <-time.After(10 * time.Second):
+		t.Fatal("adding events blocked on the stalled store")
+	}
+	s.mu.Unlock()
+	s.Close()
+
+	if s.dropped.Load() == 0 || !strings.Contains(warn.String(), "for the slow event store") {
+		t.Errorf("expected the dropped events to be reported, got %d and %q", s.dropped.Load(), warn.String())
+	}
+	events, err := QueryEvents(path, StoreQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(events))+s.dropped.Load() != 6*storeBatchSize {
+		t.Errorf("expected every event to be stored or dropped, got %d and %d", len(events), s.dropped.Load())
+	}
+}
@@ -5,17 +5,29 @@
 package log
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/kubearmor/KubeArmor/KubeArmor/cert"
 	"github.com/kubearmor/kubearmor-client/k8s"
 	"google.golang.org/grpc/credentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 func loadTLSCredentials(client kubernetes.Interface, o Options) (credentials.TransportCredentials, error) {
+	if o.TLS.Enabled() {
+		// the files are enough, e.g. for KubeArmor in systemd mode on a VM
+		return o.TLS.Credentials()
+	}
+	if client == nil {
+		return nil, errors.New("no cluster to read the certificates from, use --tls-ca, --tls-cert and --tls-key")
+	}
+
 	var secret, namespace string
 	var clientCertCfg cert.CertConfig
 	if o.ReadCAFromSecret {
@@ -34,14 +46,59 @@ func loadTLSCredentials(client kubernetes.Interface, o Options) (credentials.Tra
 		ReadCACertFromSecret: o.ReadCAFromSecret,
 		SecretName:           secret,
 		Namespace:            namespace,
-		K8sClient:            client.(*kubernetes.Clientset),
 		CertPath:             cert.GetClientCertPath(o.TlsCertPath),
 		CertProvider:         o.TlsCertProvider,
 		CACertPath:           cert.GetCACertPath(o.TlsCertPath),
 	}
-	creds, err := cert.NewTlsCredentialManager(&tlsConfig).CreateTlsClientCredentials()
+	manager := cert.NewTlsCredentialManager(&tlsConfig)
+	if manager == nil {
+		return nil, fmt.Errorf("unknown certificate provider %q", o.TlsCertProvider)
+	}
+	if o.TlsCertProvider == SelfCertProvider && o.ReadCAFromSecret {
+		// read the CA through the interface, fake clientsets included
+		manager.CertLoader = &secretCertLoader{
+			certConfig: clientCertCfg,
+			client:     client,
+			namespace:  namespace,
+			secret:     secret,
+		}
+	}
+	creds, err := manager.CreateTlsClientCredentials()
 	if err != nil {
 		fmt.Println(err.Error())
 	}
 	return creds, err
 }
+
+// secretCertLoader creates a client certificate signed by the CA in a
+// Kubernetes secret, as cert.K8sCertLoader with any kubernetes.Interface
+type secretCertLoader struct {
+	certConfig cert.CertConfig
+	client     kubernetes.Interface
+	namespace  string
+	secret     string
+}
+
+// GetCertificateAndCaPool Function
+func (l *secretCertLoader) GetCertificateAndCaPool() (*tls.Certificate, *x509.CertPool, error) {
+	secret, err := l.client.CoreV1().Secrets(l.namespace).Get(context.Background(), l.secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := cert.GetCertKeyPairFromCertBytes(&cert.CertBytes{Crt: secret.Data["tls.crt"], Key: secret.Data["tls.key"]})
+	if err != nil {
+		return nil, nil, err
+	}
+	caCertPool := x509.NewCertPool()
+	caCertPool.AddCert(caCert.Crt)
+
+	certBytes, err := cert.GenerateSelfSignedCert(caCert, &l.certConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientCert, err := tls.X509KeyPair(certBytes.Crt, certBytes.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &clientCert, caCertPool, nil
+}
@@ -0,0 +1,148 @@
+package log
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubearmor/KubeArmor/KubeArmor/cert"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	"github.com/kubearmor/kubearmor-client/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCA generates a CA and returns its PEM certificate and key
+func testCA(t *testing.T) (*cert.CertKeyPair, *cert.CertBytes) {
+	t.Helper()
+	cfg := cert.DefaultKubeArmorCAConfig
+	cfg.NotAfter = time.Now().Add(time.Hour)
+	caBytes, err := cert.GenerateCA(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := cert.GetCertKeyPairFromCertBytes(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, caBytes
+}
+
+// writeCert signs a certificate with the CA and writes it to dir
+func writeCert(t *testing.T, ca *cert.CertKeyPair, cfg cert.CertConfig, dir, name string) (string, string) {
+	t.Helper()
+	cfg.NotAfter = time.Now().Add(time.Hour)
+	b, err := cert.GenerateSelfSignedCert(ca, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, key := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(crt, b.Crt, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key, b.Key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return crt, key
+}
+
+func TestTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca, caBytes := testCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, caBytes.Crt, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	serverCfg := cert.DefaultKubeArmorServerConfig
+	serverCfg.DNS = []string{"kubearmor.vm"}
+	serverCrt, serverKey := writeCert(t, ca, serverCfg, dir, "server")
+	clientCrt, clientKey := writeCert(t, ca, cert.DefaultKubeArmorClientConfig, dir, "client")
+
+	serverCert, err := tls.LoadX509KeyPair(serverCrt, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caBytes.Crt)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	handshake := func(o utils.TLSOptions) error {
+		config, err := o.Config()
+		if err != nil {
+			return err
+		}
+		conn, err := tls.Dial("tcp", ln.Addr().String(), config)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// the server verifies the client certificate after the client is done
+		_, err = conn.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	mtls := utils.TLSOptions{CA: caFile, Cert: clientCrt, Key: clientKey, ServerName: "kubearmor.vm"}
+	if err := handshake(mtls); err != nil {
+		t.Errorf("mutual TLS failed: %v", err)
+	}
+	if err := handshake(utils.TLSOptions{CA: caFile, ServerName: "kubearmor.vm"}); err == nil {
+		t.Error("expected the server to require a client certificate")
+	}
+	if err := handshake(utils.TLSOptions{CA: caFile, Cert: clientCrt, Key: clientKey, ServerName: "other"}); err == nil {
+		t.Error("expected a server name mismatch")
+	}
+	if err := handshake(utils.TLSOptions{Cert: clientCrt, Key: clientKey, InsecureSkipVerify: true}); err != nil {
+		t.Errorf("expected the verification to be skipped: %v", err)
+	}
+	if _, err := (utils.TLSOptions{Cert: clientCrt}).Config(); err == nil {
+		t.Error("expected an error for a certificate without its key")
+	}
+
+	// no cluster is needed with files
+	if creds, err := loadTLSCredentials(nil, Options{TLS: mtls}); err != nil || creds == nil {
+		t.Errorf("unexpected credentials from files: %v", err)
+	}
+	if _, err := loadTLSCredentials(nil, Options{Secure: true, TlsCertProvider: SelfCertProvider}); err == nil {
+		t.Error("expected an error without a cluster nor files")
+	}
+}
+
+func TestTLSFromSecret(t *testing.T) {
+	_, caBytes := testCA(t)
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kubearmor", Name: "kubearmor-ca", Labels: k8s.KubeArmorCALabels},
+		Data:       map[string][]byte{"tls.crt": caBytes.Crt, "tls.key": caBytes.Key},
+	})
+	creds, err := loadTLSCredentials(client, Options{Secure: true, ReadCAFromSecret: true, TlsCertProvider: SelfCertProvider})
+	if err != nil || creds == nil {
+		t.Fatalf("unexpected credentials from the secret: %v", err)
+	}
+}
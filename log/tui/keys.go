@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package tui
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+)
+
+type keyMap struct {
+	Quit   key.Binding
+	Arrow  key.Binding
+	Filter key.Binding
+	Pause  key.Binding
+	Detail key.Binding
+	Export key.Binding
+}
+
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Quit, k.Arrow, k.Filter, k.Pause, k.Detail, k.Export}}
+}
+
+var keys = keyMap{
+	Quit: key.NewBinding(
+		key.WithKeys("ctrl+c", "q"),
+		key.WithHelp("", "(q)quit"),
+	),
+	Arrow: key.NewBinding(
+		key.WithKeys(""),
+		key.WithHelp("", "(arrow keys or h j k l) scroll"),
+	),
+	Filter: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("", "(f)filter expression"),
+	),
+	Pause: key.NewBinding(
+		key.WithKeys("p", " "),
+		key.WithHelp("", "(p)pause/resume"),
+	),
+	Detail: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("", "(enter)details, (esc)back"),
+	),
+	Export: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("", "(e)export the filtered alerts"),
+	),
+}
@@ -0,0 +1,527 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+// Package tui is an interactive terminal UI for the alerts of karmor logs
+package tui
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/evertras/bubble-table/table"
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/k8s"
+	klog "github.com/kubearmor/kubearmor-client/log"
+)
+
+// Column keys
+const (
+	ColumnID        = "ID"
+	ColumnTime      = "Time"
+	ColumnSeverity  = "Severity"
+	ColumnPolicy    = "Policy"
+	ColumnPod       = "Pod"
+	ColumnOperation = "Operation"
+	ColumnResource  = "Resource"
+	ColumnAction    = "Action"
+)
+
+// maxAlerts bounds the alerts kept in memory, the oldest are dropped first
+const maxAlerts = 10000
+
+// maxAlertBatch bounds the alerts already received that are shown at once,
+// the table is updated once per batch
+const maxAlertBatch = 256
+
+// viewState is the pane shown
+type viewState uint
+
+const (
+	tableView viewState = iota
+	filterView
+	detailView
+)
+
+var (
+	styleBase = lipgloss.NewStyle().
+			BorderForeground(lipgloss.Color("12")).
+			Align(lipgloss.Left)
+	columnStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#00af00")).Align(lipgloss.Left)
+	resourceStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("202")).Align(lipgloss.Left)
+	statusStyle = lipgloss.NewStyle().Bold(true)
+	errorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+
+	helptheme = lipgloss.AdaptiveColor{
+		Light: "#000000",
+		Dark:  "#ffffff",
+	}
+)
+
+// alertMsg are the alerts received from the observer
+type alertMsg struct {
+	alerts []*pb.Alert
+}
+
+// closedMsg signals that the observer stopped
+type closedMsg struct{}
+
+// statusMsg is a message of the observer, e.g. a lost connection
+type statusMsg string
+
+// statusWriter shows the messages written to it in the status line
+type statusWriter struct {
+	p *tea.Program
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if line := strings.TrimSpace(string(b)); line != "" {
+		w.p.Send(statusMsg(strings.ReplaceAll(line, "\n", " ")))
+	}
+	return len(b), nil
+}
+
+// entry is an alert kept by the model
+type entry struct {
+	id   int
+	res  map[string]interface{}
+	data []byte
+}
+
+// rowBuffer keeps the rows of the table newest first, with room in front
+// of them so that adding a row does not move the others
+type rowBuffer struct {
+	buf   []table.Row
+	start int
+}
+
+// prepend adds the newest row
+func (r *rowBuffer) prepend(row table.Row) {
+	if r.start == 0 {
+		n := len(r.buf)
+		buf := make([]table.Row, n+max(n, 64))
+		r.start = len(buf) - n
+		copy(buf[r.start:], r.buf)
+		r.buf = buf
+	}
+	r.start--
+	r.buf[r.start] = row
+}
+
+// dropBefore removes the oldest rows, of the alerts before id
+func (r *rowBuffer) dropBefore(id int) {
+	for len(r.buf) > r.start {
+		if last, _ := r.buf[len(r.buf)-1].Data[ColumnID].(int); last >= id {
+			return
+		}
+		r.buf = r.buf[:len(r.buf)-1]
+	}
+}
+
+func (r *rowBuffer) rows() []table.Row {
+	return r.buf[r.start:]
+}
+
+// Model for the alerts TUI
+type Model struct {
+	alerts  <-chan *pb.Alert
+	entries []entry
+	nextID  int
+	rows    rowBuffer // of the entries matching the filter
+
+	filter      *klog.Filter
+	filterInput textinput.Model
+	filterErr   string
+
+	paused  bool
+	pending int
+	stopped bool
+	status  string
+
+	// ExportDir is where the filtered alerts are exported to
+	ExportDir string
+
+	table  table.Model
+	detail viewport.Model
+	state  viewState
+
+	keys keyMap
+	help help.Model
+
+	height int
+	width  int
+}
+
+func waitForAlert(alerts <-chan *pb.Alert) tea.Cmd {
+	return func() tea.Msg {
+		a, ok := <-alerts
+		if !ok {
+			return closedMsg{}
+		}
+		msg := alertMsg{alerts: []*pb.Alert{a}}
+		for len(msg.alerts) < maxAlertBatch {
+			select {
+			case a, ok := <-alerts:
+				if !ok {
+					// closedMsg follows on the next wait
+					return msg
+				}
+				msg.alerts = append(msg.alerts, a)
+			default:
+				return msg
+			}
+		}
+		return msg
+	}
+}
+
+func generateColumns() []table.Column {
+	return []table.Column{
+		table.NewFlexColumn(ColumnTime, "Time", 3).WithStyle(columnStyle),
+		table.NewFlexColumn(ColumnSeverity, "Severity", 1).WithStyle(columnStyle),
+		table.NewFlexColumn(ColumnPolicy, "Policy", 3).WithStyle(columnStyle),
+		table.NewFlexColumn(ColumnPod, "Pod", 3).WithStyle(columnStyle),
+		table.NewFlexColumn(ColumnOperation, "Operation", 1).WithStyle(columnStyle),
+		table.NewFlexColumn(ColumnResource, "Resource", 6).WithStyle(resourceStyle),
+		table.NewFlexColumn(ColumnAction, "Action", 1).WithStyle(columnStyle),
+	}
+}
+
+// NewModel creates the model showing the alerts received on the channel
+func NewModel(alerts <-chan *pb.Alert) Model {
+	input := textinput.New()
+	input.Prompt = "filter> "
+	input.Placeholder = "Operation==File AND Action==Block"
+
+	return Model{
+		alerts:      alerts,
+		filterInput: input,
+		ExportDir:   ".",
+		table:       table.New(generateColumns()).WithBaseStyle(styleBase).WithPageSize(30).Focused(true),
+		detail:      viewport.New(80, 30),
+		keys:        keys,
+		help:        help.New(),
+	}
+}
+
+// Init waits for the first alert
+func (m Model) Init() tea.Cmd {
+	return waitForAlert(m.alerts)
+}
+
+// Update handles incoming alerts and key presses
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		m.width = msg.Width
+		m.help.Width = msg.Width
+		m.table = m.table.WithTargetWidth(msg.Width).WithPageSize(max(msg.Height-10, 5))
+		m.detail.Width = msg.Width
+		m.detail.Height = max(msg.Height-6, 5)
+		return m, nil
+
+	case alertMsg:
+		for _, a := range msg.alerts {
+			m.add(a)
+		}
+		if !m.paused {
+			m.table = m.table.WithRows(m.rows.rows())
+		}
+		return m, waitForAlert(m.alerts)
+
+	case closedMsg:
+		m.stopped = true
+		m.status = "observer stopped"
+		return m, nil
+
+	case statusMsg:
+		m.status = string(msg)
+		return m, nil
+
+	case tea.KeyMsg:
+		switch m.state {
+		case filterView:
+			return m.updateFilter(msg)
+		case detailView:
+			switch msg.String() {
+			case "esc", "enter", "q":
+				m.state = tableView
+				return m, nil
+			}
+			m.detail, cmd = m.detail.Update(msg)
+			return m, cmd
+		}
+
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Filter):
+			m.state = filterView
+			m.filterErr = ""
+			return m, m.filterInput.Focus()
+		case key.Matches(msg, m.keys.Pause):
+			m.paused = !m.paused
+			if !m.paused {
+				// catch up on the alerts kept meanwhile
+				for _, e := range m.entries[len(m.entries)-min(m.pending, len(m.entries)):] {
+					m.show(e)
+				}
+				m.pending = 0
+				m.table = m.table.WithRows(m.rows.rows())
+			}
+			return m, nil
+		case key.Matches(msg, m.keys.Detail):
+			m.showDetail()
+			return m, nil
+		case key.Matches(msg, m.keys.Export):
+			if file, err := m.Export(); err != nil {
+				m.status = "export failed: " + err.Error()
+			} else {
+				m.status = "exported to " + file
+			}
+			return m, nil
+		}
+		m.table, cmd = m.table.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// updateFilter edits the filter expression
+func (m Model) updateFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.state = tableView
+		m.filterInput.Blur()
+		return m, nil
+	case "enter":
+		expr := strings.TrimSpace(m.filterInput.Value())
+		if expr == "" {
+			m.filter = nil
+		} else {
+			flt, err := klog.CompileFilter(expr)
+			if err != nil {
+				m.filterErr = err.Error()
+				return m, nil
+			}
+			m.filter = flt
+		}
+		m.state = tableView
+		m.filterInput.Blur()
+		m.refresh()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.filterInput, cmd = m.filterInput.Update(msg)
+	return m, cmd
+}
+
+// add keeps an alert and adds its row unless paused
+func (m *Model) add(a *pb.Alert) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return
+	}
+	m.nextID++
+	e := entry{id: m.nextID, res: res, data: data}
+	m.entries = append(m.entries, e)
+	if len(m.entries) > maxAlerts {
+		m.entries = m.entries[len(m.entries)-maxAlerts:]
+	}
+
+	if m.paused {
+		m.pending++
+		return
+	}
+	m.show(e)
+}
+
+// show adds the row of a new alert if it matches the filter, and removes the
+// rows of the alerts no longer kept
+func (m *Model) show(e entry) {
+	if m.filter == nil || m.filter.Match(e.res) {
+		m.rows.prepend(table.NewRow(table.RowData{
+			ColumnID:        e.id,
+			ColumnTime:      field(e.res, "UpdatedTime"),
+			ColumnSeverity:  field(e.res, "Severity"),
+			ColumnPolicy:    field(e.res, "PolicyName"),
+			ColumnPod:       field(e.res, "PodName"),
+			ColumnOperation: field(e.res, "Operation"),
+			ColumnResource:  field(e.res, "Resource"),
+			ColumnAction:    field(e.res, "Action"),
+		}))
+	}
+	m.rows.dropBefore(m.entries[0].id)
+}
+
+// visible returns the alerts matching the filter, the newest first
+func (m *Model) visible() []entry {
+	var out []entry
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if m.filter == nil || m.filter.Match(m.entries[i].res) {
+			out = append(out, m.entries[i])
+		}
+	}
+	return out
+}
+
+// refresh rebuilds the rows of the table once the filter changed
+func (m *Model) refresh() {
+	m.rows = rowBuffer{}
+	for _, e := range m.entries {
+		m.show(e)
+	}
+	m.table = m.table.WithRows(m.rows.rows())
+}
+
+// showDetail opens the full JSON of the highlighted alert
+func (m *Model) showDetail() {
+	id, ok := m.table.HighlightedRow().Data[ColumnID].(int)
+	if !ok {
+		return
+	}
+	for _, e := range m.entries {
+		if e.id != id {
+			continue
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, e.data, "", "  "); err != nil {
+			return
+		}
+		m.detail.SetContent(pretty.String())
+		m.detail.GotoTop()
+		m.state = detailView
+		return
+	}
+}
+
+// Export writes the alerts matching the filter to a JSON file
+func (m *Model) Export() (string, error) {
+	var alerts []json.RawMessage
+	visible := m.visible()
+	for i := len(visible) - 1; i >= 0; i-- {
+		alerts = append(alerts, visible[i].data)
+	}
+	arr, err := json.MarshalIndent(alerts, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(m.ExportDir, "karmor-alerts-"+time.Now().Format("20060102-150405")+".json")
+	if err := os.WriteFile(file, arr, 0o600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+func field(res map[string]interface{}, key string) string {
+	switch v := res[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// View renders the TUI
+func (m Model) View() string {
+	helpKey := m.help.Styles.FullDesc.Foreground(helptheme).Padding(0, 0, 1)
+	helpView := helpKey.Render(m.help.FullHelpView(m.keys.FullHelp()))
+
+	status := fmt.Sprintf("%d/%d alerts", len(m.table.GetVisibleRows()), len(m.entries))
+	if m.filter != nil {
+		status += "  filter: " + m.filter.String()
+	}
+	if m.paused {
+		status += fmt.Sprintf("  PAUSED (%d new)", m.pending)
+	}
+	if m.status != "" {
+		status += "  " + m.status
+	}
+	lines := []string{helpView, statusStyle.Render(status)}
+
+	switch m.state {
+	case filterView:
+		lines = append(lines, m.filterInput.View())
+		if m.filterErr != "" {
+			lines = append(lines, errorStyle.Render(m.filterErr))
+		}
+		lines = append(lines, m.table.View())
+	case detailView:
+		lines = append(lines, m.detail.View())
+	default:
+		lines = append(lines, m.table.View())
+	}
+
+	return lipgloss.NewStyle().
+		Height(m.height).
+		MaxHeight(m.height).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// Start observes the policy alerts matching the options and shows them in
+// the TUI until quit. The TUI is the only output, so sinks and other log
+// filters are rejected. The messages of the observer, e.g. reconnects, go to
+// o.Stderr, or to the status line if it is nil.
+func Start(c *k8s.Client, o klog.Options) error {
+	if len(o.Sinks) != 0 {
+		return errors.New("the TUI cannot write to sinks")
+	}
+	if o.LogFilter != "" && o.LogFilter != "policy" {
+		return fmt.Errorf("the TUI shows the policy alerts, not the %s logs", o.LogFilter)
+	}
+	o.LogFilter = "policy"
+	o.LogPath = "none"
+	o.MsgPath = "none"
+
+	status := &statusWriter{}
+	if o.Stderr == nil {
+		o.Stderr = status
+	}
+	ob, err := klog.NewObserver(c, o)
+	if err != nil {
+		return err
+	}
+	p := tea.NewProgram(NewModel(ob.Alerts()), tea.WithAltScreen())
+	status.p = p
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ob.Run(ctx)
+	}()
+
+	_, err = p.Run()
+	cancel()
+	_ = ob.Close()
+	if runErr := <-errCh; runErr != nil {
+		return runErr
+	}
+	return err
+}
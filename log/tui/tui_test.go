@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	pb "github.com/kubearmor/KubeArmor/protobuf"
+)
+
+func update(t *testing.T, m Model, msgs ...tea.Msg) Model {
+	t.Helper()
+	for _, msg := range msgs {
+		next, _ := m.Update(msg)
+		m = next.(Model)
+	}
+	return m
+}
+
+func typeKeys(s string) []tea.Msg {
+	var msgs []tea.Msg
+	for _, r := range s {
+		msgs = append(msgs, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	return msgs
+}
+
+func TestModel(t *testing.T) {
+	m := NewModel(nil)
+	m.ExportDir = t.TempDir()
+	m = update(t, m,
+		alertMsg{[]*pb.Alert{{PolicyName: "block-shadow", Operation: "File", Action: "Block", Resource: "/etc/shadow"}}},
+		alertMsg{[]*pb.Alert{{PolicyName: "audit-curl", Operation: "Process", Action: "Audit", Resource: "/usr/bin/curl"}}},
+	)
+	if got := len(m.table.GetVisibleRows()); got != 2 {
+		t.Fatalf("expected 2 rows, got %d", got)
+	}
+
+	// an invalid expression keeps the filter pane open
+	m = update(t, m, append(append([]tea.Msg{tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")}}, typeKeys("Action==")...),
+		tea.KeyMsg{Type: tea.KeyEnter})...)
+	if m.state != filterView || m.filterErr == "" {
+		t.Fatal("expected a filter error")
+	}
+	m = update(t, m, append(typeKeys("Block"), tea.KeyMsg{Type: tea.KeyEnter})...)
+	if m.state != tableView || m.filter == nil {
+		t.Fatal("expected the filter to apply")
+	}
+	if rows := m.table.GetVisibleRows(); len(rows) != 1 || rows[0].Data[ColumnPolicy] != "block-shadow" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+
+	// paused, alerts are kept but the table is not refreshed
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")},
+		alertMsg{[]*pb.Alert{{PolicyName: "block-passwd", Action: "Block"}}})
+	if len(m.table.GetVisibleRows()) != 1 || m.pending != 1 {
+		t.Fatal("expected the table to stay paused")
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	if len(m.table.GetVisibleRows()) != 2 {
+		t.Fatal("expected the table to catch up on resume")
+	}
+
+	// the messages of the observer show in the status line
+	m = update(t, m, statusMsg("Lost connection to the gRPC server (EOF), reconnecting in 1s (attempt 1/-1)"))
+	if !strings.Contains(m.View(), "Lost connection to the gRPC server") {
+		t.Error("expected the connection error in the status line")
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.state != detailView {
+		t.Fatal("expected the detail pane")
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	file, err := m.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []pb.Alert
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 2 || exported[0].PolicyName != "block-shadow" || exported[1].PolicyName != "block-passwd" {
+		t.Errorf("unexpected export %+v", exported)
+	}
+}
+
+func TestModelRows(t *testing.T) {
+	m := NewModel(nil)
+	var alerts []*pb.Alert
+	for i := 0; i < maxAlerts+5; i++ {
+		action := "Audit"
+		if i%2 == 0 {
+			action = "Block"
+		}
+		alerts = append(alerts, &pb.Alert{PolicyName: strconv.Itoa(i), Action: action})
+	}
+	m = update(t, m, alertMsg{alerts})
+
+	// the alerts already received are shown at once
+	ch := make(chan *pb.Alert, 3)
+	ch <- alerts[0]
+	ch <- alerts[1]
+	close(ch)
+	if msg, ok := waitForAlert(ch)().(alertMsg); !ok || len(msg.alerts) != 2 {
+		t.Errorf("expected a batch of 2 alerts, got %v", msg)
+	}
+	if _, ok := waitForAlert(ch)().(closedMsg); !ok {
+		t.Error("expected the observer to be stopped")
+	}
+	rows := m.table.GetVisibleRows()
+	// the rows of the alerts no longer kept are removed, the newest first
+	if len(rows) != maxAlerts || rows[0].Data[ColumnPolicy] != strconv.Itoa(maxAlerts+4) ||
+		rows[len(rows)-1].Data[ColumnPolicy] != "5" {
+		t.Fatalf("unexpected %d rows from %v to %v", len(rows), rows[0].Data, rows[len(rows)-1].Data)
+	}
+
+	m = update(t, m, append(append([]tea.Msg{tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")}}, typeKeys("Action==Block")...),
+		tea.KeyMsg{Type: tea.KeyEnter})...)
+	m = update(t, m, alertMsg{[]*pb.Alert{{PolicyName: "audited", Action: "Audit"}}},
+		alertMsg{[]*pb.Alert{{PolicyName: "blocked", Action: "Block"}}})
+	if rows := m.table.GetVisibleRows(); len(rows) != maxAlerts/2 || rows[0].Data[ColumnPolicy] != "blocked" {
+		t.Errorf("unexpected %d rows from %v", len(rows), rows[0].Data)
+	}
+}
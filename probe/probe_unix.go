@@ -439,7 +439,11 @@ func getPolicyData(o Options) (*pb.ProbeResponse, error) {
 			gRPC = "localhost:32767"
 		}
 	}
-	conn, err := grpc.Dial(gRPC, grpc.WithInsecure())
+	creds, err := o.TLS.DialOption()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(gRPC, creds)
 	if err != nil {
 		return nil, err
 	}
@@ -4,6 +4,7 @@ import (
 	"io"
 
 	tp "github.com/kubearmor/KubeArmor/KubeArmor/types"
+	"github.com/kubearmor/kubearmor-client/utils"
 )
 
 // Options provides probe daemonset options install
@@ -12,6 +13,7 @@ type Options struct {
 	Full      bool
 	Output    string
 	GRPC      string
+	TLS       utils.TLSOptions
 	Writer    io.Writer
 }
 
@@ -8,8 +8,13 @@ import (
 
 	"github.com/evertras/bubble-table/table"
 	pb "github.com/kubearmor/KubeArmor/protobuf"
+	klog "github.com/kubearmor/kubearmor-client/log"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// labelSelector is parsed from ProfileOpts.Labels
+var labelSelector labels.Selector
+
 func generateRowFromLog(entry pb.Log) table.Row {
 	logType := "Container"
 	if entry.Type == "HostLog" {
@@ -55,6 +60,9 @@ func isCorrectLog(entry pb.Log) bool {
 	if (ProfileOpts.Container != "") && (entry.ContainerName != ProfileOpts.Container) {
 		return false
 	}
+	if labelSelector != nil && !klog.MatchLabels(labelSelector, entry.Labels) {
+		return false
+	}
 
 	return true
 }
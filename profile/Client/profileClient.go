@@ -15,6 +15,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/evertras/bubble-table/table"
 	pb "github.com/kubearmor/KubeArmor/protobuf"
+	klog "github.com/kubearmor/kubearmor-client/log"
 	profile "github.com/kubearmor/kubearmor-client/profile"
 	log "github.com/sirupsen/logrus"
 )
@@ -64,6 +65,7 @@ type Options struct {
 	Pod       string
 	GRPC      string
 	Container string
+	Labels    []string
 	Save      bool
 }
 
@@ -441,7 +443,15 @@ type Profile struct {
 }
 
 // Start entire TUI
-func Start() {
+func Start() error {
+	if len(ProfileOpts.Labels) != 0 {
+		sel, err := klog.ParseLabelSelector(ProfileOpts.Labels)
+		if err != nil {
+			return err
+		}
+		labelSelector = sel
+	}
+
 	p := tea.NewProgram(NewModel(), tea.WithAltScreen())
 	go func() {
 		err := profile.GetLogs(ProfileOpts.GRPC)
@@ -461,4 +471,5 @@ func Start() {
 	default:
 		break
 	}
+	return nil
 }
@@ -5,16 +5,16 @@
 package profile
 
 import (
-	"errors"
+	"context"
 
 	pb "github.com/kubearmor/KubeArmor/protobuf"
 	"github.com/kubearmor/kubearmor-client/k8s"
 	klog "github.com/kubearmor/kubearmor-client/log"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
-var eventChan chan klog.EventInfo
+// logChan receives the system logs of the observer
+var logChan <-chan *pb.Log
 
 // ErrChan to make error channels from goroutines
 var ErrChan chan error
@@ -23,61 +23,65 @@ var EventChan = make(chan pb.Log)
 
 // GetLogs to fetch logs
 func GetLogs(grpc string) error {
-	errCh := KarmorProfileStart("system", grpc)
-	var err error
-	if eventChan == nil {
-		log.Error("event channel not set. Did you call KarmorQueueLog()?")
-		return errors.New("event channel not set")
+	// the UI redraws in between, drop the oldest logs rather than pausing
+	// the stream meanwhile, without warnings over the UI
+	events, err := klog.NewDelivery(EventChan, klog.DefaultDeliveryBuffer, klog.OverflowDropOldest, "logs", nil)
+	if err != nil {
+		return err
 	}
+	defer events.Close()
 
-	for eventChan != nil {
+	errCh := KarmorProfileStart("system", grpc)
+	for {
 		select {
-		case evtin := <-eventChan:
-			if evtin.Type == "Log" {
-				log := pb.Log{}
-				err := protojson.Unmarshal(evtin.Data, &log)
-				if err != nil {
-					return err
+		case evt, ok := <-logChan:
+			if !ok {
+				// the observer stopped, report why
+				if errCh == nil {
+					return nil
 				}
-				EventChan <- log
-			} else {
-				log.Errorf("UNKNOWN EVT type %s", evtin.Type)
+				return <-errCh
 			}
-		// }
+			events.Send(*evt)
 		case err := <-errCh:
-			return err
+			if err != nil || logChan == nil {
+				return err
+			}
+			// the stream ended, forward the logs left
+			errCh = nil
 		}
 	}
-	return err
 }
 
 // KarmorProfileStart starts observer
 func KarmorProfileStart(logFilter string, grpc string) <-chan error {
 	ErrChan = make(chan error, 1)
-	if eventChan == nil {
-		eventChan = make(chan klog.EventInfo)
-	}
 	client, err := k8s.ConnectK8sClient()
 	if err != nil {
 		ErrChan <- err
+		return ErrChan
 	}
 
-	go func() {
-		// defer close(ErrChan)
-		err = klog.StartObserver(client, klog.Options{
-			LogFilter: logFilter,
-			MsgPath:   "none",
-			EventChan: eventChan,
-			GRPC:      grpc,
-		})
+	observer, err := klog.NewObserver(client, klog.Options{
+		LogFilter: logFilter,
+		MsgPath:   "none",
+		LogPath:   "none",
+		GRPC:      grpc,
+	})
+	if err != nil {
+		ErrChan <- err
+		return ErrChan
+	}
+	logChan = observer.Logs()
 
-		select {
-		case ErrChan <- err:
+	go func(errCh chan<- error) {
+		// the result is always sent, nil once the stream ends
+		err := observer.Run(context.Background())
+		if err != nil {
 			log.Errorf("failed to start observer. Error=%s", err.Error())
-		default:
-			break
 		}
-	}()
+		errCh <- err
+	}(ErrChan)
 
 	return ErrChan
 }
@@ -28,6 +28,8 @@ type PortForwardOpt struct {
 	Namespace   string
 	PodName     string
 	TargetSvc   string
+
+	stopChan chan struct{}
 }
 
 // InitiatePortForward : Initiate port forwarding
@@ -48,28 +50,50 @@ func InitiatePortForward(c *k8s.Client, localPort int64, remotePort int64, match
 	return pf, nil
 }
 
+// InitiatePodPortForward : Initiate port forwarding to the given pod, from
+// a free local port set in LocalPort
+func InitiatePodPortForward(c *k8s.Client, remotePort int64, namespace string, podName string) (PortForwardOpt, error) {
+	pf := PortForwardOpt{
+		LocalPort:  0,
+		RemotePort: remotePort,
+		Namespace:  namespace,
+		PodName:    podName,
+		TargetSvc:  podName,
+	}
+
+	// handle port forward
+	err := pf.handlePortForward(c)
+	if err != nil {
+		return pf, err
+	}
+	return pf, nil
+}
+
 // handle port forward to allow grpc to connect at localhost:PORT
 func (pf *PortForwardOpt) handlePortForward(c *k8s.Client) error {
 	if err := pf.getPodName(c); err != nil {
 		return err
 	}
 
-	// local port
-	lp, err := pf.getLocalPort()
-	if err != nil {
-		return err
+	// local port, the forwarder binds a free one for 0
+	if pf.LocalPort != 0 {
+		lp, err := pf.getLocalPort()
+		if err != nil {
+			return err
+		}
+		pf.LocalPort = lp
 	}
-	pf.LocalPort = lp
 
-	err = k8sPortForward(c, *pf)
+	pf.stopChan = make(chan struct{}, 1)
+	err := k8sPortForward(c, pf)
 	if err != nil {
 		return fmt.Errorf("\ncould not do kubearmor portforward, error=%s", err.Error())
 	}
 	return nil
 }
 
-// k8s port forward
-func k8sPortForward(c *k8s.Client, pf PortForwardOpt) error {
+// k8s port forward, it sets the local port bound by the forwarder
+func k8sPortForward(c *k8s.Client, pf *PortForwardOpt) error {
 	roundTripper, upgrader, err := spdy.RoundTripperFor(c.Config)
 	if err != nil {
 		return fmt.Errorf("\nunable to create round tripper and upgrader, error=%s", err.Error())
@@ -83,11 +107,11 @@ func k8sPortForward(c *k8s.Client, pf PortForwardOpt) error {
 
 	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, serverURL)
 
-	StopChan, readyChan := make(chan struct{}, 1), make(chan struct{}, 1)
+	readyChan := make(chan struct{}, 1)
 	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
 
 	forwarder, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", pf.LocalPort, pf.RemotePort)},
-		StopChan, readyChan, out, errOut)
+		pf.stopChan, readyChan, out, errOut)
 	if err != nil {
 		return fmt.Errorf("\nunable to portforward. error=%s", err.Error())
 	}
@@ -103,12 +127,31 @@ func k8sPortForward(c *k8s.Client, pf PortForwardOpt) error {
 		forwarder.Close()
 		return fmt.Errorf("could not create port forward %s", err)
 	case <-readyChan:
+		ports, err := forwarder.GetPorts()
+		if err != nil {
+			forwarder.Close()
+			return fmt.Errorf("could not get the forwarded port %s", err)
+		}
+		pf.LocalPort = int64(ports[0].Local)
 		return nil
 	}
 }
 
-// Get pod name to enable port forward
+// Stop closes the port forward
+func (pf *PortForwardOpt) Stop() {
+	if pf.stopChan == nil {
+		return
+	}
+	close(pf.stopChan)
+	pf.stopChan = nil
+}
+
+// Get pod name to enable port forward, unless a pod is given
 func (pf *PortForwardOpt) getPodName(c *k8s.Client) error {
+	if pf.PodName != "" && pf.Namespace != "" {
+		return nil
+	}
+
 	labelSelector := metav1.LabelSelector{
 		MatchLabels: pf.MatchLabels,
 	}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSOptions are the files to connect to the gRPC server of KubeArmor over
+// TLS, or mutual TLS with a client certificate, without a cluster
+type TLSOptions struct {
+	CA                 string // CA bundle to verify the server, the system pool if empty
+	Cert               string // client certificate for mutual TLS
+	Key                string // key of the client certificate
+	ServerName         string // server name to verify instead of the host dialed
+	InsecureSkipVerify bool   // do not verify the server certificate
+}
+
+// Enabled returns whether any TLS option is set
+func (t TLSOptions) Enabled() bool {
+	return t.CA != "" || t.Cert != "" || t.Key != "" || t.ServerName != "" || t.InsecureSkipVerify
+}
+
+// Config builds the TLS client configuration from the files
+func (t TLSOptions) Config() (*tls.Config, error) {
+	if (t.Cert == "") != (t.Key == "") {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+
+	// #nosec G402 -- skipping the verification is an explicit opt-in
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CA != "" {
+		pem, err := os.ReadFile(filepath.Clean(t.CA))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle %s", t.CA)
+		}
+		config.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Credentials returns the gRPC transport credentials of the options
+func (t TLSOptions) Credentials() (credentials.TransportCredentials, error) {
+	config, err := t.Config()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// DialOption returns the gRPC transport credentials to dial with, TLS if
+// any option is set and plaintext otherwise
+func (t TLSOptions) DialOption() (grpc.DialOption, error) {
+	if !t.Enabled() {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+	creds, err := t.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
@@ -14,9 +14,9 @@ import (
 
 	tp "github.com/kubearmor/KubeArmor/KubeArmor/types"
 	pb "github.com/kubearmor/KubeArmor/protobuf"
+	"github.com/kubearmor/kubearmor-client/utils"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"sigs.k8s.io/yaml"
 )
 
@@ -32,6 +32,7 @@ const (
 // PolicyOptions are optional configuration for kArmor vm policy
 type PolicyOptions struct {
 	GRPC string
+	TLS  utils.TLSOptions
 }
 
 func sendPolicyOverGRPC(o PolicyOptions, policyEventData []byte, kind string) error {
@@ -51,7 +52,11 @@ func sendPolicyOverGRPC(o PolicyOptions, policyEventData []byte, kind string) er
 		}
 	}
 
-	conn, err := grpc.NewClient(gRPC, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := o.TLS.DialOption()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.NewClient(gRPC, creds)
 	if err != nil {
 		return err
 	}